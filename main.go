@@ -1,10 +1,12 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"io/ioutil"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"gopkg.in/yaml.v2"
 
@@ -12,25 +14,28 @@ import (
 )
 
 func main() {
-	a := readConfig()
-	a.Setup()
-	go a.CheckLoop()
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-	http.Handle("/webhook", a)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", a.Port), nil))
+	a := alertdog.New(readConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if err := a.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func readConfig() *alertdog.Alertdog {
-	var a *alertdog.Alertdog
+func readConfig() alertdog.Config {
+	var config alertdog.Config
 	configFile, err := ioutil.ReadFile("config.yml")
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = yaml.Unmarshal(configFile, &a)
+	err = yaml.Unmarshal(configFile, &config)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return a
+	return config
 }
@@ -1,18 +1,21 @@
 package alertdog
 
 import (
-	"os"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/PagerDuty/go-pagerduty"
 	"github.com/prometheus/alertmanager/template"
+	"go.uber.org/atomic"
 
 	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
 )
 
 type Alertmanager interface {
@@ -20,153 +23,339 @@ type Alertmanager interface {
 	Resolve(alertmanager.Alert) error
 }
 
-type Pagerduty interface {
-	ManageEvent(event pagerduty.V2Event) (*pagerduty.V2EventResponse, error)
-}
-
-type Alertdog struct {
+// Config is the configuration of an Alertdog, as read from config.yml
+type Config struct {
 	AlertmanagerEndpoints []string `yaml:"alertmanager_endpoints"`
 	Expected              []*Prometheus
 	CheckInterval         time.Duration `yaml:"check_interval"`
+	CheckJitter           float64       `yaml:"check_jitter"`
 	Expiry                time.Duration
 	Port                  uint
+	Notifiers             []NotifierConfig
+	Escalation            []EscalationStep
 	PagerDutyKey          string `yaml:"pager_duty_key"`
-	PagerDutyRunbookURL   string `yaml:"pagerduty_runbook_url"`
+	// Routing keys for each type of incident, used instead of PagerDutyKey
+	PagerDutyKeys       map[string]string `yaml:"pager_duty_keys"`
+	PagerDutyRunbookURL string            `yaml:"pagerduty_runbook_url"`
+	QueueSize           int               `yaml:"queue_size"`
+	Workers             int
+	WebhookAuth         *WebhookAuth  `yaml:"webhook_auth"`
+	WebConfigFile       string        `yaml:"web_config_file"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout"`
+	StateFile           string        `yaml:"state_file"`
+	// Identifies this alertdog, e.g. by environment, it namespaces incident
+	// keys so alertdogs sharing a notifier don't resolve each other's incidents
+	Instance string
+	// Alerts are pushed here when every one of AlertmanagerEndpoints fails
+	SecondaryAlertmanagerEndpoints []string `yaml:"secondary_alertmanager_endpoints"`
+	// How the default PagerDuty notifier sends events, when there are no Notifiers
+	PagerDuty *notify.PagerDutyConfig `yaml:"pagerduty"`
+	defaulted bool
+}
+
+type Alertdog struct {
+	Config
 
 	mu           sync.RWMutex
 	checkedIn    time.Time
+	deliveries   atomic.Uint64
+	index        *labelIndex
+	queue        chan pendingAction
+	queueMu      sync.RWMutex
+	workers      sync.WaitGroup
 	alertmanager Alertmanager
-	pagerduty    Pagerduty
+	secondary    Alertmanager
+	failover     *failover
+	notifiers    []*trackedNotifier
+	escalation   *escalation
+	// escalationErr is why the escalation policy doesn't match the
+	// notifiers, Serve returns it rather than paging every notifier
+	escalationErr error
+	// notifyCtx is cancelled on shutdown, so notifiers stop retrying
+	notifyCtx     context.Context
+	stopNotifying context.CancelFunc
+	clock         Clock
+	logger        *log.Logger
+	mux           *http.ServeMux
 }
 
-func (a *Alertdog) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	defaultInterval, _ := time.ParseDuration("2m")
-	a.CheckInterval = defaultInterval
-	defaultExpiry, _ := time.ParseDuration("5m")
-	a.Expiry = defaultExpiry
-	// https://github.com/prometheus/prometheus/wiki/Default-port-allocations
-	a.Port = 9796
-	a.PagerDutyKey = os.Getenv("PAGER_DUTY_KEY")
-	type plain Alertdog
-	return unmarshal((*plain)(a))
+// applyDefaults sets each field of c that is zero to its default, and those
+// of c.Expected. A config is only defaulted once, so a field set to zero in
+// a config file after its defaults were applied is kept.
+func (c *Config) applyDefaults() {
+	for _, prometheus := range c.Expected {
+		prometheus.applyDefaults()
+	}
+	if c.defaulted {
+		return
+	}
+	c.defaulted = true
+	if c.CheckInterval == 0 {
+		c.CheckInterval = 2 * time.Minute
+	}
+	if c.CheckJitter == 0 {
+		c.CheckJitter = 0.1
+	}
+	if c.Expiry == 0 {
+		c.Expiry = 5 * time.Minute
+	}
+	if c.Port == 0 {
+		// https://github.com/prometheus/prometheus/wiki/Default-port-allocations
+		c.Port = 9796
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 100
+	}
+	if c.Workers == 0 {
+		c.Workers = 4
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 30 * time.Second
+	}
+	if c.PagerDutyKey == "" {
+		c.PagerDutyKey = os.Getenv("PAGER_DUTY_KEY")
+	}
 }
 
-func (a *Alertdog) Setup() {
-	a.alertmanager = alertmanager.Alertmanager{Endpoints: a.AlertmanagerEndpoints, Expiry: a.CheckInterval * 2}
-	a.pagerduty = PagerdutyClient{}
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.applyDefaults()
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.PagerDuty != nil && len(c.Notifiers) > 0 {
+		return errors.New("pagerduty: only configures the default notifier, set it on a pagerduty notifier in notifiers instead")
+	}
+	if c.CheckJitter < 0 || c.CheckJitter >= 1 {
+		return fmt.Errorf("check_jitter must be at least 0 and less than 1, got %v", c.CheckJitter)
+	}
+	if err := notify.ValidateRoutingKeys(c.PagerDutyKeys); err != nil {
+		return fmt.Errorf("pager_duty_keys: %w", err)
+	}
+	return c.validateEscalation()
+}
+
+// New returns an Alertdog for config.
+// By default alerts are pushed to config.AlertmanagerEndpoints, failing over
+// to config.SecondaryAlertmanagerEndpoints, and incidents raised with
+// config.Notifiers, options can be used to replace them.
+func New(config Config, options ...Option) *Alertdog {
+	config.applyDefaults()
+	a := &Alertdog{
+		Config: config,
+		clock:  realClock{},
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	a.notifyCtx, a.stopNotifying = context.WithCancel(context.Background())
+	for _, option := range options {
+		option(a)
+	}
+	if a.alertmanager == nil {
+		a.alertmanager = alertmanager.Alertmanager{Endpoints: a.AlertmanagerEndpoints, Expiry: a.alertExpiry(), Logf: a.logger.Printf}
+	}
+	if a.secondary == nil && len(a.SecondaryAlertmanagerEndpoints) > 0 {
+		a.secondary = alertmanager.Alertmanager{Endpoints: a.SecondaryAlertmanagerEndpoints, Expiry: a.alertExpiry(), Logf: a.logger.Printf}
+	}
+	if a.secondary != nil {
+		a.failover = newFailover(a.alertmanager, a.secondary, a.logger.Printf)
+		a.alertmanager = a.failover
+	}
+	if a.notifiers == nil {
+		for _, config := range a.notifierConfigs() {
+			a.notifiers = append(a.notifiers, config.tracked())
+		}
+	}
+	ownMux := a.mux == nil
+	if ownMux {
+		a.mux = http.NewServeMux()
+	}
+	for _, notifier := range a.notifiers {
+		notifier.clock = a.clock
+	}
+	a.escalation, a.escalationErr = a.newEscalation()
+	for _, prometheus := range a.Expected {
+		prometheus.clock = a.clock
+		if prometheus.fallback == nil && prometheus.Fallback != nil {
+			prometheus.fallback = newFallbackNotifier(*prometheus.Fallback)
+		}
+		if prometheus.fallback != nil {
+			prometheus.fallback.clock = a.clock
+			// An alert is only delivered directly while alertmanager is
+			// down, so there is nothing to resolve unless it was
+			prometheus.fallback.resolveOnlyTriggered = true
+		}
+	}
+	a.index = newLabelIndex(a.Expected)
+	a.register(a.mux, ownMux)
+	return a
+}
+
+// webhookMessage is the body of a request from the alertmanager webhook receiver
+type webhookMessage struct {
+	template.Data
+	GroupKey string `json:"groupKey"`
+}
+
+// Delivery identifies a single webhook request
+type Delivery struct {
+	ID       uint64
+	GroupKey string
+	Received time.Time
 }
 
 func (a *Alertdog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	var data template.Data
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		log.Printf("Webhook body invalid, skipping request: %s", err)
+	if a.WebhookAuth != nil {
+		if reason, err := a.WebhookAuth.authenticate(r); err != nil {
+			webhookAuthFailures.WithLabelValues(reason).Inc()
+			a.logger.Printf("Webhook request from %s rejected: %s", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	var message webhookMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		a.logger.Printf("Webhook body invalid, skipping request: %s", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	for _, alert := range data.Alerts {
-		a.processWatchdog(alert)
+	delivery := a.newDelivery(message.GroupKey)
+	for _, alert := range message.Alerts {
+		for _, pending := range a.recordWatchdog(delivery, alert) {
+			a.enqueue(pending)
+		}
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *Alertdog) processWatchdog(alert template.Alert) {
+func (a *Alertdog) newDelivery(groupKey string) Delivery {
+	return Delivery{
+		ID:       a.deliveries.Inc(),
+		GroupKey: groupKey,
+		Received: a.clock.Now(),
+	}
+}
+
+func (a *Alertdog) processWatchdog(delivery Delivery, alert template.Alert) {
+	for _, pending := range a.recordWatchdog(delivery, alert) {
+		a.act(pending.prometheus, pending.action)
+	}
+}
+
+// recordWatchdog checks in a watchdog, and returns the actions that it causes
+func (a *Alertdog) recordWatchdog(delivery Delivery, alert template.Alert) []pendingAction {
 	a.CheckIn()
-	for _, prometheus := range a.Expected {
-		var err error
-		switch action := prometheus.CheckIn(alert); action {
-		case ActionAlert:
-			err = a.alertmanager.Alert(prometheus.Alert)
-		case ActionResolve:
-			err = a.alertmanager.Resolve(prometheus.Alert)
+	var actions []pendingAction
+	for _, prometheus := range a.index.match(alert.Labels) {
+		if action := prometheus.CheckIn(delivery, alert); action != ActionNone {
+			actions = append(actions, pendingAction{prometheus: prometheus, action: action})
+		}
+	}
+	return actions
+}
+
+// act pushes the alert affected by action to alertmanager, raising a
+// incident if that fails, and resolving it once a push succeeds
+func (a *Alertdog) act(prometheus *Prometheus, action AlertAction) {
+	if action == ActionNone {
+		return
+	}
+	pushed := ActionNone
+	err := prometheus.sequence(action, func(action AlertAction) error {
+		pushed = action
+		switch action {
+		case ActionAlert, ActionAlertDegraded, ActionAlertFlapping:
+			return a.alertmanager.Alert(prometheus.alertFor(action))
+		case ActionResolve, ActionResolveDegraded, ActionResolveFlapping:
+			return a.alertmanager.Resolve(prometheus.alertFor(action))
 		}
-		if err != nil {
-			a.pagerDutyAlert("alertdog:alertmanager-push", "Alertdog cannot push alerts to alertmanager")
+		return nil
+	})
+	if err != nil {
+		a.trigger(a.alertmanagerPushIncident(prometheus, err))
+	} else if pushed != ActionNone {
+		a.resolve(a.alertmanagerPushIncident(prometheus, nil))
+	}
+	if pushed != ActionNone && prometheus.fallback != nil {
+		a.fallback(prometheus, pushed, err)
+	}
+}
+
+// alertExpiry is how long alerts pushed to alertmanager last, long enough
+// that they won't end before they are next checked.
+func (a *Alertdog) alertExpiry() time.Duration {
+	interval := a.CheckInterval
+	for _, prometheus := range a.Expected {
+		if prometheus.CheckInterval > interval {
+			interval = prometheus.CheckInterval
 		}
 	}
+	return 2 * (interval + time.Duration(float64(interval)*a.CheckJitter))
 }
 
-func (a *Alertdog) CheckLoop() {
-	checkExpiryTicker := time.NewTicker(a.CheckInterval)
-	for {
-		<-checkExpiryTicker.C
-		a.Check()
+// CheckLoop checks each expected prometheus when its watchdog expires, or
+// when its check interval has passed. The webhook expiry is checked in the
+// same way. It returns once ctx is cancelled, and any running check is complete.
+func (a *Alertdog) CheckLoop(ctx context.Context) {
+	s := scheduler{clock: a.clock}
+	now := a.clock.Now()
+	for _, prometheus := range a.Expected {
+		prometheus := prometheus
+		interval := prometheus.CheckInterval
+		if interval == 0 {
+			interval = a.CheckInterval
+		}
+		s.add(now.Add(jitter(interval, a.CheckJitter)), func(now time.Time) time.Time {
+			a.act(prometheus, prometheus.Check())
+			return prometheus.nextCheck(now, jitter(interval, a.CheckJitter))
+		})
 	}
+	s.add(now.Add(jitter(a.CheckInterval, a.CheckJitter)), func(now time.Time) time.Time {
+		a.checkWebhook()
+		return a.nextCheck(now, jitter(a.CheckInterval, a.CheckJitter))
+	})
+	s.run(ctx.Done())
 }
 
 func (a *Alertdog) Check() {
 	for _, prometheus := range a.Expected {
-		if action := prometheus.Check(); action == ActionAlert {
-			if err := a.alertmanager.Alert(prometheus.Alert); err != nil {
-				a.pagerDutyAlert("alertdog:alertmanager-push", "Alertdog cannot push alerts to alertmanager")
-			}
-		}
+		a.act(prometheus, prometheus.Check())
 	}
+	a.checkWebhook()
+}
+
+func (a *Alertdog) checkWebhook() {
 	if a.Expired() {
-		a.pagerDutyAlert(
-			"alertdog:webhook-expiry",
-			fmt.Sprintf("Alertdog: didn't receive webhook from alert manager for over %v", a.Expiry),
-		)
+		a.trigger(a.webhookExpiryIncident())
 	} else {
-		a.pagerDutyResolve("alertdog:webhook-expiry")
+		a.resolve(a.webhookExpiryIncident())
 	}
 }
 
 func (a *Alertdog) CheckIn() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.checkedIn = time.Now()
+	a.checkedIn = a.clock.Now()
 }
 
-func (a *Alertdog) Expired() bool {
+// nextCheck returns when the webhook expiry should next be checked,
+// when it expires or after interval, whichever is sooner.
+func (a *Alertdog) nextCheck(now time.Time, interval time.Duration) time.Time {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	return time.Now().After(a.checkedIn.Add(a.Expiry))
-}
-
-func (a *Alertdog) pagerDutyAlert(dedupKey, summary string) {
-	log.Println("PagerDuty: ", summary)
-	event := pagerduty.V2Event{
-		Action:     "trigger",
-		RoutingKey: a.PagerDutyKey,
-		DedupKey:   dedupKey,
-		Payload: &pagerduty.V2Payload{
-			Summary:  summary,
-			Source:   dedupKey,
-			Severity: "critical",
-		},
-		Images: []interface{}{
-			map[string]string{
-				"src": "https://github.com/errm/alertdog/raw/main/docs/dog.jpg",
-			},
-		},
-	}
-	if a.PagerDutyRunbookURL != "" {
-		event.Links = []interface{}{
-			map[string]string{
-				"text": "Runbook 📕",
-				"href": a.PagerDutyRunbookURL,
-			},
-		}
-	}
-	if response, err := a.pagerduty.ManageEvent(event); err != nil {
-		log.Printf("Error raising alert on pagerduty: %s %+v", err, response)
-	}
+	return nextCheck(now, a.checkedIn.Add(a.Expiry), interval)
 }
 
-func (a *Alertdog) pagerDutyResolve(dedupKey string) {
-	event := pagerduty.V2Event{
-		Action:     "resolve",
-		RoutingKey: a.PagerDutyKey,
-		DedupKey:   dedupKey,
-	}
-	if response, err := a.pagerduty.ManageEvent(event); err != nil {
-		log.Printf("Error resolving alert on pagerduty: %s %+v", err, response)
+func nextCheck(now, expiry time.Time, interval time.Duration) time.Time {
+	next := now.Add(interval)
+	if expiry.After(now) && expiry.Before(next) {
+		return expiry
 	}
+	return next
 }
 
-type PagerdutyClient struct{}
-
-func (p PagerdutyClient) ManageEvent(event pagerduty.V2Event) (*pagerduty.V2EventResponse, error) {
-	return pagerduty.ManageEvent(event)
+func (a *Alertdog) Expired() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return !a.clock.Now().Before(a.checkedIn.Add(a.Expiry))
 }
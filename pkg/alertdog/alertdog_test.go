@@ -1,15 +1,23 @@
 package alertdog
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/PagerDuty/go-pagerduty"
 	"github.com/prometheus/alertmanager/template"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 
 	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
 )
 
 type AlertmanagerMock struct {
@@ -26,14 +34,41 @@ func (a *AlertmanagerMock) Resolve(alert alertmanager.Alert) error {
 	return args.Error(0)
 }
 
-type PagerdutyMock struct {
+type NotifierMock struct {
 	mock.Mock
 }
 
-func (p *PagerdutyMock) ManageEvent(event pagerduty.V2Event) (*pagerduty.V2EventResponse, error) {
-	args := p.Called(event)
-	response := &pagerduty.V2EventResponse{}
-	return response, args.Error(0)
+func (n *NotifierMock) Trigger(ctx context.Context, incident notify.Incident) error {
+	args := n.Called(incident)
+	return args.Error(0)
+}
+
+func (n *NotifierMock) Resolve(ctx context.Context, incident notify.Incident) error {
+	args := n.Called(incident)
+	return args.Error(0)
+}
+
+// pushResolved matches the alertmanager-push incident, that is resolved by
+// every successful push
+var pushResolved = mock.MatchedBy(func(incident notify.Incident) bool {
+	return incident.Type == notify.TypeAlertmanagerPush
+})
+
+// newNotifierMock returns a NotifierMock that accepts the alertmanager-push
+// incident being resolved
+func newNotifierMock() *NotifierMock {
+	notifierMock := &NotifierMock{}
+	notifierMock.On("Resolve", pushResolved).Return(nil).Maybe()
+	return notifierMock
+}
+
+// matchIncident matches incidents equal to expected, apart from the time of the
+// last webhook
+func matchIncident(expected notify.Incident) interface{} {
+	return mock.MatchedBy(func(incident notify.Incident) bool {
+		incident.Context.LastWebhook = time.Time{}
+		return reflect.DeepEqual(expected, incident)
+	})
 }
 
 type expectation struct {
@@ -71,37 +106,31 @@ func TestProcessWatchdog(t *testing.T) {
 		Alert: alert2,
 	}
 
-	alertdog := Alertdog{Expected: []*Prometheus{prom1, prom2}, PagerDutyKey: "pagerduty-key", PagerDutyRunbookURL: "https://example.org/runbook-url"}
+	alertdog := New(Config{Expected: []*Prometheus{prom1, prom2}, PagerDutyRunbookURL: "https://example.org/runbook-url"}, WithNotifiers(newNotifierMock()))
 
 	error := errors.New("alertmanager is broken")
 
-	pagerDutyEvent := pagerduty.V2Event{
-		Action:     "trigger",
-		RoutingKey: "pagerduty-key",
-		DedupKey:   "alertdog:alertmanager-push",
-		Payload: &pagerduty.V2Payload{
-			Summary:  "Alertdog cannot push alerts to alertmanager",
-			Source:   "alertdog:alertmanager-push",
-			Severity: "critical",
-		},
-		Images: []interface{}{
-			map[string]string{
-				"src": "https://github.com/errm/alertdog/raw/main/docs/dog.jpg",
-			},
+	incident := notify.Incident{
+		Key:      "alertdog:alertmanager-push",
+		Type:     notify.TypeAlertmanagerPush,
+		Summary:  "Alertdog cannot push alerts to alertmanager",
+		Severity: "critical",
+		Images: []notify.Image{
+			{Src: "https://github.com/errm/alertdog/raw/main/docs/dog.jpg"},
 		},
-		Links: []interface{}{
-			map[string]string{
-				"text": "Runbook 📕",
-				"href": "https://example.org/runbook-url",
-			},
+		Links: []notify.Link{
+			{Text: "Runbook 📕", Href: "https://example.org/runbook-url"},
+		},
+		Context: notify.Context{
+			AffectedTargets: []map[string]string{prom1.MatchLabels},
 		},
 	}
 
 	var tests = []struct {
-		description           string
-		expectations          []expectation
-		watchdogs             []template.Alert
-		pagerdutyExpectations []expectation
+		description          string
+		expectations         []expectation
+		watchdogs            []template.Alert
+		notifierExpectations []expectation
 	}{
 		{
 			description:  "When we receive a resolved watchdog: alert with the correct alert",
@@ -190,9 +219,9 @@ func TestProcessWatchdog(t *testing.T) {
 			},
 		},
 		{
-			description:           "When alertmanager errors, raise a pagerduty event",
-			expectations:          []expectation{expectation{method: "Alert", arg: alert1, err: error}},
-			pagerdutyExpectations: []expectation{expectation{method: "ManageEvent", arg: pagerDutyEvent}},
+			description:          "When alertmanager errors, raise an event",
+			expectations:         []expectation{expectation{method: "Alert", arg: alert1, err: error}},
+			notifierExpectations: []expectation{expectation{method: "Trigger", arg: matchIncident(incident)}},
 			watchdogs: []template.Alert{
 				template.Alert{
 					Status: "resolved",
@@ -244,22 +273,55 @@ func TestProcessWatchdog(t *testing.T) {
 			alertmanagerMock.On(expectation.method, expectation.arg).Return(expectation.err)
 		}
 
-		pagerdutyMock := &PagerdutyMock{}
-		alertdog.pagerduty = pagerdutyMock
+		notifierMock := &NotifierMock{}
+		alertdog.notifiers[0].Notifier = notifierMock
 
-		for _, expectation := range test.pagerdutyExpectations {
-			pagerdutyMock.On(expectation.method, expectation.arg).Return(expectation.err)
+		for _, expectation := range test.notifierExpectations {
+			notifierMock.On(expectation.method, expectation.arg).Return(expectation.err)
 		}
+		notifierMock.On("Resolve", pushResolved).Return(nil).Maybe()
 
 		for _, watchdog := range test.watchdogs {
-			alertdog.processWatchdog(watchdog)
+			alertdog.processWatchdog(alertdog.newDelivery(""), watchdog)
 		}
 
 		alertmanagerMock.AssertExpectations(t)
-		pagerdutyMock.AssertExpectations(t)
+		notifierMock.AssertExpectations(t)
 	}
 }
 
+func TestAlertmanagerPushIncident(t *testing.T) {
+	alert := alertmanager.Alert{Name: "PrometheusAlertFailure"}
+	prometheus := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog"}, Alert: alert, Expiry: time.Minute}
+	alertmanagerMock := &AlertmanagerMock{}
+	notifierMock := &NotifierMock{}
+	alertdog := New(Config{Expected: []*Prometheus{prometheus}}, WithClock(newFakeClock()), WithAlertmanager(alertmanagerMock), WithNotifiers(notifierMock))
+	incident := mock.MatchedBy(func(incident notify.Incident) bool {
+		return incident.Key == "alertdog:alertmanager-push"
+	})
+	push := func(err error) {
+		alertmanagerMock.On("Alert", alert).Return(err).Once()
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.AssertExpectations(t)
+	}
+
+	notifierMock.On("Trigger", incident).Return(nil).Once()
+	push(errors.New("alertmanager is down"))
+	notifierMock.AssertExpectations(t)
+
+	// The incident is resolved by hand, then alertmanager recovers
+	notifierMock.On("Resolve", incident).Return(nil).Once()
+	push(nil)
+	notifierMock.AssertExpectations(t)
+
+	// The next outage is paged again
+	notifierMock.On("Trigger", incident).Return(nil).Once()
+	push(errors.New("alertmanager is down again"))
+	notifierMock.AssertExpectations(t)
+	notifierMock.AssertNumberOfCalls(t, "Trigger", 2)
+	notifierMock.AssertNumberOfCalls(t, "Resolve", 1)
+}
+
 func TestCheck(t *testing.T) {
 	alert1 := alertmanager.Alert{
 		Labels: map[string]string{
@@ -273,41 +335,36 @@ func TestCheck(t *testing.T) {
 		},
 	}
 
-	pagerDutyEvent := pagerduty.V2Event{
-		Action:     "trigger",
-		RoutingKey: "this-is-a-key",
-		DedupKey:   "alertdog:webhook-expiry",
-		Payload: &pagerduty.V2Payload{
-			Summary:  "Alertdog: didn't receive webhook from alert manager for over 2m0s",
-			Source:   "alertdog:webhook-expiry",
-			Severity: "critical",
+	// neither prometheus has checked in enough times to be healthy
+	incident := matchIncident(notify.Incident{
+		Key:      "alertdog:webhook-expiry",
+		Type:     notify.TypeWebhookExpiry,
+		Summary:  "Alertdog: didn't receive webhook from alert manager for over 2m0s",
+		Severity: "critical",
+		Images: []notify.Image{
+			{Src: "https://github.com/errm/alertdog/raw/main/docs/dog.jpg"},
 		},
-		Images: []interface{}{
-			map[string]string{
-				"src": "https://github.com/errm/alertdog/raw/main/docs/dog.jpg",
+		Context: notify.Context{
+			AffectedTargets: []map[string]string{
+				{"alertname": "Watchdog", "prometheus": "prom1"},
+				{"alertname": "Watchdog", "prometheus": "prom2"},
 			},
 		},
-	}
-
-	pagerDutyResolveEvent := pagerduty.V2Event{
-		Action:     "resolve",
-		RoutingKey: "this-is-a-key",
-		DedupKey:   "alertdog:webhook-expiry",
-	}
+	})
 
 	var tests = []struct {
-		description           string
-		expectations          []expectation
-		pagerdutyExpectations []expectation
-		watchdogs             []template.Alert
+		description          string
+		expectations         []expectation
+		notifierExpectations []expectation
+		watchdogs            []template.Alert
 	}{
 		{
-			description: "If no watchdogs are received, then fire all alerts, and raise a pagerduty incident",
+			description: "If no watchdogs are received, then fire all alerts, and raise an incident",
 			expectations: []expectation{
 				expectation{method: "Alert", arg: alert1},
 				expectation{method: "Alert", arg: alert2},
 			},
-			pagerdutyExpectations: []expectation{expectation{method: "ManageEvent", arg: pagerDutyEvent}},
+			notifierExpectations: []expectation{expectation{method: "Trigger", arg: incident}},
 		},
 		{
 			description: "Fire the alert if the watchdog was missing",
@@ -323,7 +380,7 @@ func TestCheck(t *testing.T) {
 					},
 				},
 			},
-			pagerdutyExpectations: []expectation{expectation{method: "ManageEvent", arg: pagerDutyResolveEvent}},
+			notifierExpectations: []expectation{expectation{method: "Resolve", arg: incident}},
 		},
 		{
 			description: "Don't fire if watchdogs were received",
@@ -343,7 +400,7 @@ func TestCheck(t *testing.T) {
 					},
 				},
 			},
-			pagerdutyExpectations: []expectation{expectation{method: "ManageEvent", arg: pagerDutyResolveEvent}},
+			notifierExpectations: []expectation{expectation{method: "Resolve", arg: incident}},
 		},
 		{
 			description: "Fire if only resolves where received",
@@ -367,16 +424,16 @@ func TestCheck(t *testing.T) {
 					},
 				},
 			},
-			pagerdutyExpectations: []expectation{expectation{method: "ManageEvent", arg: pagerDutyResolveEvent}},
+			notifierExpectations: []expectation{expectation{method: "Resolve", arg: incident}},
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
 			alertmanagerMock := &AlertmanagerMock{}
-			pagerdutyMock := &PagerdutyMock{}
+			notifierMock := &NotifierMock{}
 
-			alertdog := Alertdog{
+			alertdog := New(Config{
 				Expected: []*Prometheus{
 					&Prometheus{
 						MatchLabels: map[string]string{
@@ -395,27 +452,529 @@ func TestCheck(t *testing.T) {
 						Expiry: time.Minute,
 					},
 				},
-				PagerDutyKey: "this-is-a-key",
-				Expiry:       time.Minute * 2,
-				pagerduty:    pagerdutyMock,
-				alertmanager: alertmanagerMock,
-			}
+				Expiry: time.Minute * 2,
+			}, WithNotifiers(notifierMock), WithAlertmanager(alertmanagerMock))
 
 			for _, expectation := range test.expectations {
 				alertmanagerMock.On(expectation.method, expectation.arg).Return(expectation.err)
 			}
 
-			for _, expectation := range test.pagerdutyExpectations {
-				pagerdutyMock.On(expectation.method, expectation.arg).Return(expectation.err)
+			for _, expectation := range test.notifierExpectations {
+				notifierMock.On(expectation.method, expectation.arg).Return(expectation.err)
 			}
+			notifierMock.On("Resolve", pushResolved).Return(nil).Maybe()
 
 			for _, watchdog := range test.watchdogs {
-				alertdog.processWatchdog(watchdog)
+				alertdog.processWatchdog(alertdog.newDelivery(""), watchdog)
 			}
 
 			alertdog.Check()
 			alertmanagerMock.AssertExpectations(t)
-			pagerdutyMock.AssertExpectations(t)
+			notifierMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckReplicas(t *testing.T) {
+	alert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "down",
+		},
+	}
+
+	degradedAlert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert":    "degraded",
+			"severity": "warning",
+		},
+	}
+
+	watchdog := func(replica string) template.Alert {
+		return template.Alert{
+			Status: "firing",
+			Labels: template.KV{
+				"alertname":          "Watchdog",
+				"prometheus":         "prom1",
+				"prometheus_replica": replica,
+			},
+		}
+	}
+
+	var tests = []struct {
+		description  string
+		expectations []expectation
+		watchdogs    []template.Alert
+	}{
+		{
+			description:  "Don't fire if all replicas check in",
+			expectations: []expectation{expectation{method: "Resolve", arg: alert}},
+			watchdogs:    []template.Alert{watchdog("a"), watchdog("b")},
+		},
+		{
+			description: "Fire the degraded alert if a replica is missing",
+			expectations: []expectation{
+				expectation{method: "Resolve", arg: alert},
+				expectation{method: "Alert", arg: degradedAlert},
+			},
+			watchdogs: []template.Alert{watchdog("a"), watchdog("a")},
+		},
+		{
+			description:  "Fire the failure alert, not the degraded alert, if every replica is missing",
+			expectations: []expectation{expectation{method: "Alert", arg: alert}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			alertmanagerMock := &AlertmanagerMock{}
+			notifierMock := &NotifierMock{}
+			notifierMock.On("Trigger", mock.Anything).Return(nil)
+			notifierMock.On("Resolve", mock.Anything).Return(nil)
+
+			alertdog := New(Config{
+				Expected: []*Prometheus{
+					&Prometheus{
+						MatchLabels: map[string]string{
+							"alertname":  "Watchdog",
+							"prometheus": "prom1",
+						},
+						Alert:         alert,
+						Expiry:        time.Minute,
+						ReplicaLabel:  "prometheus_replica",
+						MinReplicas:   2,
+						DegradedAlert: degradedAlert,
+					},
+				},
+				Expiry: time.Minute * 2,
+			}, WithNotifiers(notifierMock), WithAlertmanager(alertmanagerMock))
+
+			for _, expectation := range test.expectations {
+				alertmanagerMock.On(expectation.method, expectation.arg).Return(expectation.err)
+			}
+
+			for _, watchdog := range test.watchdogs {
+				alertdog.processWatchdog(alertdog.newDelivery(""), watchdog)
+			}
+
+			alertdog.Check()
+			alertmanagerMock.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Resolve the degraded alert once enough replicas are back", func(t *testing.T) {
+		alertmanagerMock := &AlertmanagerMock{}
+		prometheus := &Prometheus{
+			MatchLabels: map[string]string{
+				"alertname":  "Watchdog",
+				"prometheus": "prom1",
+			},
+			Alert:         alert,
+			Expiry:        time.Minute,
+			ReplicaLabel:  "prometheus_replica",
+			MinReplicas:   2,
+			DegradedAlert: degradedAlert,
+		}
+		alertdog := New(Config{
+			Expected: []*Prometheus{prometheus},
+			Expiry:   time.Minute * 2,
+		}, WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+		alertmanagerMock.On("Resolve", alert).Return(nil)
+		alertmanagerMock.On("Alert", degradedAlert).Return(nil).Once()
+		alertmanagerMock.On("Resolve", degradedAlert).Return(nil).Once()
+
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("a"))
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("a"))
+		alertdog.act(prometheus, prometheus.Check())
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("b"))
+		alertdog.act(prometheus, prometheus.Check())
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.AssertExpectations(t)
+	})
+
+	t.Run("Resolve the degraded alert once every replica has expired", func(t *testing.T) {
+		alertmanagerMock := &AlertmanagerMock{}
+		clock := newFakeClock()
+		prometheus := &Prometheus{
+			MatchLabels: map[string]string{
+				"alertname":  "Watchdog",
+				"prometheus": "prom1",
+			},
+			Alert:         alert,
+			Expiry:        time.Minute,
+			ReplicaLabel:  "prometheus_replica",
+			MinReplicas:   2,
+			DegradedAlert: degradedAlert,
+		}
+		alertdog := New(Config{
+			Expected: []*Prometheus{prometheus},
+			Expiry:   time.Minute * 2,
+		}, WithClock(clock), WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+		alertmanagerMock.On("Resolve", alert).Return(nil).Once()
+		alertmanagerMock.On("Alert", degradedAlert).Return(nil).Once()
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("a"))
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("a"))
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.AssertExpectations(t)
+
+		// The failure alert is raised first, then the degraded alert it
+		// supersedes is resolved, then the failure alert is repeated
+		clock.Advance(time.Minute)
+		alertmanagerMock.On("Alert", alert).Return(nil).Once()
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.On("Resolve", degradedAlert).Return(nil).Once()
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.On("Alert", alert).Return(nil).Once()
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.AssertExpectations(t)
+	})
+
+	t.Run("Watchdogs without the replica label aren't counted as a replica", func(t *testing.T) {
+		alertmanagerMock := &AlertmanagerMock{}
+		prometheus := &Prometheus{
+			MatchLabels: map[string]string{
+				"alertname":  "Watchdog",
+				"prometheus": "prom1",
+			},
+			Alert:         alert,
+			Expiry:        time.Minute,
+			ReplicaLabel:  "prometheus_replica",
+			MinReplicas:   2,
+			DegradedAlert: degradedAlert,
+		}
+		alertdog := New(Config{
+			Expected: []*Prometheus{prometheus},
+			Expiry:   time.Minute * 2,
+		}, WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+		alertmanagerMock.On("Resolve", alert).Return(nil).Once()
+		alertmanagerMock.On("Alert", degradedAlert).Return(nil).Once()
+		unlabelled := watchdog("")
+		delete(unlabelled.Labels, "prometheus_replica")
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("a"))
+		alertdog.processWatchdog(alertdog.newDelivery(""), unlabelled)
+		alertdog.act(prometheus, prometheus.Check())
+		alertmanagerMock.AssertExpectations(t)
+	})
+}
+
+func TestResolvePolicy(t *testing.T) {
+	alert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "one",
+		},
+	}
+
+	firing := template.Alert{
+		Status: "firing",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+
+	resolved := template.Alert{
+		Status: "resolved",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+
+	var tests = []struct {
+		description  string
+		prometheus   *Prometheus
+		expectations []expectation
+		watchdogs    []template.Alert
+	}{
+		{
+			description: "Wait for resolve_count watchdogs before resolving",
+			prometheus:  &Prometheus{ResolveCount: 3},
+			watchdogs:   []template.Alert{firing, firing},
+		},
+		{
+			description:  "Resolve after resolve_count watchdogs",
+			prometheus:   &Prometheus{ResolveCount: 3},
+			expectations: []expectation{expectation{method: "Resolve", arg: alert}},
+			watchdogs:    []template.Alert{firing, firing, firing, firing},
+		},
+		{
+			description: "Wait for min_healthy before resolving",
+			prometheus:  &Prometheus{MinHealthy: time.Hour},
+			watchdogs:   []template.Alert{firing, firing, firing},
+		},
+		{
+			description: "Don't alert on a resolved watchdog when it is treated as missing",
+			prometheus:  &Prometheus{OnResolved: ResolvedMissing},
+			watchdogs:   []template.Alert{resolved, firing},
+		},
+		{
+			description: "A resolved watchdog treated as missing resets the debounce",
+			prometheus:  &Prometheus{OnResolved: ResolvedMissing},
+			watchdogs:   []template.Alert{firing, resolved, firing},
+		},
+		{
+			description:  "Ignored resolved watchdogs don't reset the debounce",
+			prometheus:   &Prometheus{OnResolved: ResolvedIgnore},
+			expectations: []expectation{expectation{method: "Resolve", arg: alert}},
+			watchdogs:    []template.Alert{firing, resolved, firing},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			alertmanagerMock := &AlertmanagerMock{}
+			prometheus := test.prometheus
+			prometheus.MatchLabels = map[string]string{
+				"alertname":  "Watchdog",
+				"prometheus": "prom1",
+			}
+			prometheus.Alert = alert
+			prometheus.Expiry = time.Minute
+			alertdog := New(Config{
+				Expected: []*Prometheus{prometheus},
+			}, WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+
+			for _, expectation := range test.expectations {
+				alertmanagerMock.On(expectation.method, expectation.arg).Return(expectation.err)
+			}
+
+			for _, watchdog := range test.watchdogs {
+				alertdog.processWatchdog(alertdog.newDelivery(""), watchdog)
+			}
+
+			alertmanagerMock.AssertExpectations(t)
 		})
 	}
 }
+
+func TestUnmarshalOnResolved(t *testing.T) {
+	var prometheus Prometheus
+	require.NoError(t, yaml.Unmarshal([]byte("on_resolved: ignore"), &prometheus))
+	require.Equal(t, ResolvedIgnore, prometheus.OnResolved)
+	require.Equal(t, uint(2), prometheus.ResolveCount)
+
+	require.Error(t, yaml.Unmarshal([]byte("on_resolved: panic"), &prometheus))
+}
+
+func TestUnmarshalReplicaLabel(t *testing.T) {
+	var prometheus Prometheus
+	require.NoError(t, yaml.Unmarshal([]byte(`
+replica_label: prometheus_replica
+degraded_alert:
+  name: PrometheusReplicaFailure
+`), &prometheus))
+	require.Equal(t, "PrometheusReplicaFailure", prometheus.DegradedAlert.Name)
+
+	// Without a name the degraded alert would be pushed without an alertname
+	require.Error(t, yaml.Unmarshal([]byte("replica_label: prometheus_replica"), &Prometheus{}))
+}
+
+func TestUnmarshalFlapping(t *testing.T) {
+	var prometheus Prometheus
+	require.NoError(t, yaml.Unmarshal([]byte(`
+flap_window: 1h
+flap_alert:
+  name: PrometheusAlertFlapping
+`), &prometheus))
+	require.Equal(t, time.Hour, prometheus.FlapWindow)
+
+	require.Error(t, yaml.Unmarshal([]byte("flap_window: 1h"), &Prometheus{}))
+	// The low threshold defaults to 2, so it is higher than this one
+	require.Error(t, yaml.Unmarshal([]byte("flap_high_threshold: 1"), &Prometheus{}))
+	require.Error(t, yaml.Unmarshal([]byte(`
+flap_window: 1h
+flap_high_threshold: 2
+flap_low_threshold: 3
+flap_alert:
+  name: PrometheusAlertFlapping
+`), &Prometheus{}))
+}
+
+func TestDuplicateDelivery(t *testing.T) {
+	alert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "one",
+		},
+	}
+
+	webhook := func(groupKey string, watchdogs int) string {
+		alerts := make([]string, watchdogs)
+		for i := range alerts {
+			alerts[i] = `{"status": "firing", "labels": {"alertname": "Watchdog", "prometheus": "prom1"}}`
+		}
+		return fmt.Sprintf(`{"groupKey": %q, "alerts": [%s]}`, groupKey, strings.Join(alerts, ","))
+	}
+
+	watchdog := template.Alert{
+		Status: "firing",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+
+	now := time.Now()
+
+	var tests = []struct {
+		description  string
+		expectations []expectation
+		webhooks     []string
+		deliveries   []Delivery
+	}{
+		{
+			description: "Several matching alerts in one webhook are counted once",
+			webhooks:    []string{webhook("{}:{alertname=\"Watchdog\"}", 2)},
+		},
+		{
+			description: "The same group delivered twice within min_delivery_gap, as each alertmanager replica does, is counted once",
+			webhooks: []string{
+				webhook("{}:{alertname=\"Watchdog\"}", 1),
+				webhook("{}:{alertname=\"Watchdog\"}", 1),
+			},
+		},
+		{
+			description:  "Deliveries for different groups are counted separately",
+			expectations: []expectation{expectation{method: "Resolve", arg: alert}},
+			webhooks: []string{
+				webhook("{}:{alertname=\"Watchdog\"}", 1),
+				webhook("{}:{prometheus=\"prom1\"}", 1),
+			},
+		},
+		{
+			description:  "Deliveries separated by min_delivery_gap are counted separately",
+			expectations: []expectation{expectation{method: "Resolve", arg: alert}},
+			deliveries: []Delivery{
+				Delivery{ID: 1, GroupKey: "group", Received: now},
+				Delivery{ID: 2, GroupKey: "group", Received: now.Add(time.Minute)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			alertmanagerMock := &AlertmanagerMock{}
+			alertdog := New(Config{
+				Expected: []*Prometheus{
+					&Prometheus{
+						MatchLabels: map[string]string{
+							"alertname":  "Watchdog",
+							"prometheus": "prom1",
+						},
+						Alert:          alert,
+						Expiry:         time.Minute,
+						MinDeliveryGap: time.Minute,
+					},
+				},
+			}, WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+
+			for _, expectation := range test.expectations {
+				alertmanagerMock.On(expectation.method, expectation.arg).Return(expectation.err)
+			}
+
+			for _, body := range test.webhooks {
+				recorder := httptest.NewRecorder()
+				alertdog.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body)))
+				require.Equal(t, http.StatusOK, recorder.Code)
+			}
+
+			for _, delivery := range test.deliveries {
+				alertdog.processWatchdog(delivery, watchdog)
+			}
+
+			alertmanagerMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	alert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "one",
+		},
+	}
+
+	flapAlert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "flapping",
+		},
+	}
+
+	firing := template.Alert{
+		Status: "firing",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+
+	resolved := template.Alert{
+		Status: "resolved",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+
+	alertmanagerMock := &AlertmanagerMock{}
+	prometheus := &Prometheus{
+		MatchLabels: map[string]string{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+		Alert:             alert,
+		Expiry:            time.Minute,
+		FlapWindow:        time.Hour,
+		FlapHighThreshold: 2,
+		FlapLowThreshold:  1,
+		FlapAlert:         flapAlert,
+	}
+	alertdog := New(Config{
+		Expected: []*Prometheus{prometheus},
+	}, WithNotifiers(newNotifierMock()), WithAlertmanager(alertmanagerMock))
+
+	alertmanagerMock.On("Resolve", alert).Return(nil).Once()
+	alertmanagerMock.On("Alert", flapAlert).Return(nil).Twice()
+	for _, watchdog := range []template.Alert{firing, firing, resolved, firing, firing} {
+		alertdog.processWatchdog(alertdog.newDelivery(""), watchdog)
+	}
+	alertmanagerMock.AssertExpectations(t)
+	require.True(t, prometheus.Status().Flapping)
+	require.InDelta(t, 3.6, prometheus.Status().FlapScore, 0.01)
+
+	// Stop flapping once the state changes have aged out of the window
+	alertmanagerMock.On("Resolve", flapAlert).Return(nil).Once()
+	prometheus.mu.Lock()
+	for i := range prometheus.stateChanges {
+		prometheus.stateChanges[i] = prometheus.stateChanges[i].Add(-time.Hour)
+	}
+	prometheus.mu.Unlock()
+	alertdog.act(prometheus, prometheus.Check())
+	alertmanagerMock.AssertExpectations(t)
+	require.False(t, prometheus.Status().Flapping)
+}
+
+func TestNewDefaults(t *testing.T) {
+	alertdog := New(Config{
+		Expected: []*Prometheus{{MatchLabels: map[string]string{"alertname": "Watchdog"}}},
+	}, WithNotifiers(newNotifierMock()), WithAlertmanager(&AlertmanagerMock{}))
+	require.Equal(t, 2*time.Minute, alertdog.CheckInterval)
+	require.Equal(t, 5*time.Minute, alertdog.Expiry)
+	require.Equal(t, 30*time.Second, alertdog.ShutdownTimeout)
+	require.True(t, alertdog.alertExpiry() > 0)
+	prometheus := alertdog.Expected[0]
+	require.Equal(t, 4*time.Minute, prometheus.Expiry)
+	require.Equal(t, 10*time.Second, prometheus.MinDeliveryGap)
+	require.Equal(t, ResolvedAlert, prometheus.OnResolved)
+
+	// Zero values set in a config file are kept
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+check_jitter: 0
+expected:
+  - match_labels: {alertname: Watchdog}
+    min_delivery_gap: 0s
+`), &config))
+	alertdog = New(config, WithNotifiers(newNotifierMock()), WithAlertmanager(&AlertmanagerMock{}))
+	require.Equal(t, float64(0), alertdog.CheckJitter)
+	require.Equal(t, time.Duration(0), alertdog.Expected[0].MinDeliveryGap)
+	require.Equal(t, 4*time.Minute, alertdog.Expected[0].Expiry)
+}
@@ -0,0 +1,148 @@
+package alertdog
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/errm/alertdog/pkg/web"
+)
+
+// WebhookAuth configures how requests to the webhook are authenticated.
+// Credentials are read from files on each request, so they can be rotated
+// without restarting alertdog.
+type WebhookAuth struct {
+	// A bearer token, as sent by alertmanager's http_config.bearer_token
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	// Basic auth, as sent by alertmanager's http_config.basic_auth
+	BasicAuth *BasicAuth `yaml:"basic_auth"`
+	// CA certificates used to verify client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+type BasicAuth struct {
+	Username     string
+	PasswordFile string `yaml:"password_file"`
+}
+
+// Reasons a webhook request was rejected
+const (
+	authMissingCredentials = "missing_credentials"
+	authInvalidCredentials = "invalid_credentials"
+	authInvalidCertificate = "invalid_certificate"
+	authError              = "error"
+)
+
+// authenticate checks the credentials of a webhook request.
+// If a bearer token and basic auth are both configured either is accepted,
+// a client certificate is required in addition if client_ca_file is set.
+// The reason a request was rejected is returned along with the error.
+func (auth *WebhookAuth) authenticate(r *http.Request) (string, error) {
+	if auth.ClientCAFile != "" {
+		if reason, err := auth.verifyClientCertificate(r); err != nil {
+			return reason, err
+		}
+	}
+	if auth.BearerTokenFile == "" && auth.BasicAuth == nil {
+		return "", nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if auth.BearerTokenFile != "" && strings.HasPrefix(header, "Bearer ") {
+		token, err := readCredential(auth.BearerTokenFile)
+		if err != nil {
+			return authError, err
+		}
+		if !equal(strings.TrimPrefix(header, "Bearer "), token) {
+			return authInvalidCredentials, errors.New("invalid bearer token")
+		}
+		return "", nil
+	}
+	if username, password, ok := r.BasicAuth(); auth.BasicAuth != nil && ok {
+		expected, err := readCredential(auth.BasicAuth.PasswordFile)
+		if err != nil {
+			return authError, err
+		}
+		// Compare both, so the time taken doesn't reveal if the username was correct
+		usernameOK := equal(username, auth.BasicAuth.Username)
+		passwordOK := equal(password, expected)
+		if !usernameOK || !passwordOK {
+			return authInvalidCredentials, errors.New("invalid username or password")
+		}
+		return "", nil
+	}
+	return authMissingCredentials, errors.New("no credentials provided")
+}
+
+func (auth *WebhookAuth) verifyClientCertificate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return authMissingCredentials, errors.New("no client certificate provided")
+	}
+	caCerts, err := ioutil.ReadFile(auth.ClientCAFile)
+	if err != nil {
+		return authError, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCerts) {
+		return authError, fmt.Errorf("no certificates found in %s", auth.ClientCAFile)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return authInvalidCertificate, err
+	}
+	return "", nil
+}
+
+// readCredential reads a credential from file, an empty file is an error so
+// that it doesn't match requests with an empty credential
+func readCredential(file string) (string, error) {
+	credential, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	trimmed := strings.TrimSpace(string(credential))
+	if trimmed == "" {
+		return "", fmt.Errorf("%s is empty", file)
+	}
+	return trimmed, nil
+}
+
+// checkWebConfig checks that the web config served with requests client
+// certificates when client_ca_file is set, otherwise no request would
+// have one to verify
+func (auth *WebhookAuth) checkWebConfig(file string) error {
+	if auth.ClientCAFile == "" {
+		return nil
+	}
+	if file == "" {
+		return errors.New("webhook_auth: client_ca_file requires web_config_file with tls_server_config")
+	}
+	config, err := web.LoadConfig(file)
+	if err != nil {
+		return err
+	}
+	if config.TLSConfig == nil {
+		return fmt.Errorf("webhook_auth: client_ca_file requires tls_server_config in %s", file)
+	}
+	switch config.TLSConfig.ClientAuth {
+	case "", "NoClientCert":
+		return fmt.Errorf("webhook_auth: client_ca_file requires client_auth_type RequestClientCert or stricter in %s", file)
+	}
+	return nil
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
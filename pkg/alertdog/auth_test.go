@@ -0,0 +1,221 @@
+package alertdog
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	passwordFile := filepath.Join(dir, "password")
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("s3cr3t-token\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("s3cr3t-password\n"), 0600))
+	emptyFile := filepath.Join(dir, "empty")
+	require.NoError(t, ioutil.WriteFile(emptyFile, []byte(" \n"), 0600))
+
+	ca, caKey := newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	client, _ := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alertmanager"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	untrusted, _ := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alertmanager"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	tokenAuth := &WebhookAuth{BearerTokenFile: tokenFile}
+	basicAuth := &WebhookAuth{BasicAuth: &BasicAuth{Username: "alertmanager", PasswordFile: passwordFile}}
+	eitherAuth := &WebhookAuth{BearerTokenFile: tokenFile, BasicAuth: &BasicAuth{Username: "alertmanager", PasswordFile: passwordFile}}
+	certAuth := &WebhookAuth{ClientCAFile: caFile}
+	emptyAuth := &WebhookAuth{BearerTokenFile: emptyFile, BasicAuth: &BasicAuth{PasswordFile: emptyFile}}
+
+	var tests = []struct {
+		description string
+		auth        *WebhookAuth
+		request     func(*http.Request)
+		reason      string
+	}{
+		{
+			description: "No auth configured",
+			request:     func(*http.Request) {},
+		},
+		{
+			description: "Valid bearer token",
+			auth:        tokenAuth,
+			request:     func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t-token") },
+		},
+		{
+			description: "Invalid bearer token",
+			auth:        tokenAuth,
+			request:     func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") },
+			reason:      authInvalidCredentials,
+		},
+		{
+			description: "Missing bearer token",
+			auth:        tokenAuth,
+			request:     func(*http.Request) {},
+			reason:      authMissingCredentials,
+		},
+		{
+			description: "Valid basic auth",
+			auth:        basicAuth,
+			request:     func(r *http.Request) { r.SetBasicAuth("alertmanager", "s3cr3t-password") },
+		},
+		{
+			description: "Invalid basic auth username",
+			auth:        basicAuth,
+			request:     func(r *http.Request) { r.SetBasicAuth("admin", "s3cr3t-password") },
+			reason:      authInvalidCredentials,
+		},
+		{
+			description: "Invalid basic auth password",
+			auth:        basicAuth,
+			request:     func(r *http.Request) { r.SetBasicAuth("alertmanager", "guess") },
+			reason:      authInvalidCredentials,
+		},
+		{
+			description: "Basic auth when either is accepted",
+			auth:        eitherAuth,
+			request:     func(r *http.Request) { r.SetBasicAuth("alertmanager", "s3cr3t-password") },
+		},
+		{
+			description: "Bearer token when only basic auth is accepted",
+			auth:        basicAuth,
+			request:     func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t-token") },
+			reason:      authMissingCredentials,
+		},
+		{
+			description: "Empty bearer token file",
+			auth:        emptyAuth,
+			request:     func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+			reason:      authError,
+		},
+		{
+			description: "Empty password file",
+			auth:        emptyAuth,
+			request:     func(r *http.Request) { r.SetBasicAuth("", "") },
+			reason:      authError,
+		},
+		{
+			description: "Valid client certificate",
+			auth:        certAuth,
+			request: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}
+			},
+		},
+		{
+			description: "Untrusted client certificate",
+			auth:        certAuth,
+			request: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{untrusted}}
+			},
+			reason: authInvalidCertificate,
+		},
+		{
+			description: "Missing client certificate",
+			auth:        certAuth,
+			request:     func(*http.Request) {},
+			reason:      authMissingCredentials,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			alertdog := New(Config{WebhookAuth: test.auth})
+			request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"alerts": []}`))
+			test.request(request)
+
+			var failures float64
+			if test.reason != "" {
+				failures = testutil.ToFloat64(webhookAuthFailures.WithLabelValues(test.reason))
+			}
+
+			recorder := httptest.NewRecorder()
+			alertdog.ServeHTTP(recorder, request)
+
+			if test.reason == "" {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			} else {
+				require.Equal(t, http.StatusUnauthorized, recorder.Code)
+				require.Equal(t, failures+1, testutil.ToFloat64(webhookAuthFailures.WithLabelValues(test.reason)))
+			}
+		})
+	}
+}
+
+func TestWebhookAuthWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	webConfig := func(config string) string {
+		file := filepath.Join(dir, "web.yml")
+		require.NoError(t, ioutil.WriteFile(file, []byte(config), 0600))
+		return file
+	}
+	auth := &WebhookAuth{ClientCAFile: filepath.Join(dir, "ca.pem")}
+
+	require.NoError(t, (&WebhookAuth{}).checkWebConfig(""))
+	require.Error(t, auth.checkWebConfig(""))
+	require.Error(t, auth.checkWebConfig(webConfig("{}")))
+	require.Error(t, auth.checkWebConfig(webConfig(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+`)))
+	require.NoError(t, auth.checkWebConfig(webConfig(`
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequestClientCert
+`)))
+
+	// Alertdog doesn't start with a web config that won't request certificates
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	alertdog := New(Config{WebhookAuth: auth}, WithAlertmanager(&AlertmanagerMock{}))
+	require.Error(t, alertdog.Serve(context.Background(), listener))
+}
+
+// newCertificate creates a certificate signed by parent, or self signed
+// if parent is nil
+func newCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
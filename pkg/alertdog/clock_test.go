@@ -0,0 +1,11 @@
+package alertdog
+
+import (
+	"time"
+
+	"github.com/errm/alertdog/pkg/alertdogtest/fakeclock"
+)
+
+func newFakeClock() *fakeclock.Clock {
+	return fakeclock.New(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,246 @@
+package alertdog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+// EscalationStep is a step of the escalation policy
+type EscalationStep struct {
+	// The name of a notifier
+	Notifier string
+	// If the incident is still open, and hasn't been acknowledged after this
+	// long, it is escalated to the next step (optional) (by default incidents
+	// are only escalated if the notifier fails)
+	Timeout time.Duration
+}
+
+// validateEscalation checks that each step of the escalation policy is a
+// notifier, that can be identified by its name
+func (c Config) validateEscalation() error {
+	if len(c.Escalation) == 0 {
+		return nil
+	}
+	var notifiers []*trackedNotifier
+	for _, config := range c.notifierConfigs() {
+		name, notifier := config.notifier()
+		notifiers = append(notifiers, newTrackedNotifier(name, notifier, 0))
+	}
+	_, err := newEscalationSteps(c.Escalation, notifiers)
+	return err
+}
+
+// newEscalationSteps matches each step of the escalation policy to its
+// notifier. A step can only have a timeout if its notifier can tell if the
+// incident was acknowledged, otherwise it would always be escalated.
+func newEscalationSteps(policy []EscalationStep, notifiers []*trackedNotifier) ([]escalationStep, error) {
+	var steps []escalationStep
+	for _, step := range policy {
+		var matches []*trackedNotifier
+		for _, notifier := range notifiers {
+			if notifier.name == step.Notifier {
+				matches = append(matches, notifier)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("escalation: no notifier named %q", step.Notifier)
+		case 1:
+		default:
+			return nil, fmt.Errorf("escalation: more than one notifier named %q", step.Notifier)
+		}
+		if _, ok := matches[0].Notifier.(notify.Acknowledger); step.Timeout != 0 && !ok {
+			return nil, fmt.Errorf("escalation: %q can't tell if an incident was acknowledged, so its step can't have a timeout", step.Notifier)
+		}
+		steps = append(steps, escalationStep{EscalationStep: step, notifier: matches[0]})
+	}
+	return steps, nil
+}
+
+// newEscalation returns the escalation policy, or nil if there isn't one.
+// The notifiers passed to WithNotifiers may not match the policy, so it is
+// checked again against them.
+func (a *Alertdog) newEscalation() (*escalation, error) {
+	if len(a.Escalation) == 0 {
+		return nil, nil
+	}
+	steps, err := newEscalationSteps(a.Escalation, a.notifiers)
+	if err != nil {
+		return nil, err
+	}
+	return &escalation{
+		steps:     steps,
+		clock:     a.clock,
+		logf:      a.logger.Printf,
+		incidents: map[string]*escalationState{},
+	}, nil
+}
+
+// escalation sends incidents to the first step of the policy, escalating to
+// the next step when a step fails, or isn't acknowledged in time
+type escalation struct {
+	steps     []escalationStep
+	clock     Clock
+	logf      func(format string, v ...interface{})
+	mu        sync.Mutex
+	incidents map[string]*escalationState
+}
+
+type escalationStep struct {
+	EscalationStep
+	notifier *trackedNotifier
+}
+
+// escalationState is how far an incident has been escalated, busy is set
+// while it is being sent to a notifier
+type escalationState struct {
+	step  int
+	steps []StepStatus
+	busy  bool
+}
+
+// StepStatus is the state of an escalation step for an open incident
+type StepStatus struct {
+	Notifier     string    `json:"notifier"`
+	Triggered    time.Time `json:"triggered,omitempty"`
+	Acknowledged bool      `json:"acknowledged,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// EscalationStatus is how far an open incident has been escalated
+type EscalationStatus struct {
+	Key   string       `json:"key"`
+	Step  int          `json:"step"`
+	Steps []StepStatus `json:"steps"`
+}
+
+// trigger sends incident to the current step of its escalation, and
+// escalates it as far as is needed. The lock isn't held while the incident
+// is sent, if it is already being sent it is skipped until the next check.
+func (e *escalation) trigger(ctx context.Context, incident notify.Incident) {
+	e.mu.Lock()
+	state, ok := e.incidents[incident.Key]
+	if !ok {
+		state = &escalationState{}
+		for _, step := range e.steps {
+			state.steps = append(state.steps, StepStatus{Notifier: step.Notifier})
+		}
+		e.incidents[incident.Key] = state
+		incidentEscalationStep.WithLabelValues(incident.Key).Set(0)
+	}
+	if state.busy {
+		e.mu.Unlock()
+		return
+	}
+	state.busy = true
+	defer func() {
+		state.busy = false
+		e.mu.Unlock()
+	}()
+	for {
+		step := e.steps[state.step]
+		status := state.steps[state.step]
+		e.mu.Unlock()
+		reason := e.triggerStep(ctx, step, &status, incident)
+		e.mu.Lock()
+		state.steps[state.step] = status
+		if reason == "" {
+			return
+		}
+		if state.step == len(e.steps)-1 {
+			e.logf("Can't escalate %s past %s, %s", incident.Key, step.Notifier, reason)
+			return
+		}
+		e.logf("Escalating %s from %s, %s", incident.Key, step.Notifier, reason)
+		escalations.WithLabelValues(step.Notifier, reason).Inc()
+		state.step++
+		incidentEscalationStep.WithLabelValues(incident.Key).Set(float64(state.step))
+	}
+}
+
+// triggerStep triggers incident with step, returning why it should be
+// escalated, or "" if it shouldn't
+func (e *escalation) triggerStep(ctx context.Context, step escalationStep, status *StepStatus, incident notify.Incident) string {
+	if err := step.notifier.Trigger(ctx, incident); err != nil {
+		notifierErrors.WithLabelValues(step.Notifier).Inc()
+		status.Error = err.Error()
+		return "failed"
+	}
+	status.Error = ""
+	now := e.clock.Now()
+	if status.Triggered.IsZero() {
+		status.Triggered = now
+	}
+	if step.Timeout == 0 || now.Sub(status.Triggered) < step.Timeout {
+		return ""
+	}
+	if acknowledger, ok := step.notifier.Notifier.(notify.Acknowledger); ok {
+		acknowledged, err := acknowledger.Acknowledged(ctx, incident)
+		if err != nil {
+			e.logf("Error checking if %s is acknowledged with %s: %s", incident.Key, step.Notifier, err)
+		}
+		status.Acknowledged = acknowledged
+		if acknowledged {
+			return ""
+		}
+	}
+	return "unacknowledged"
+}
+
+// resolve resolves incident with every step it was escalated to. Until an
+// incident has been triggered its state is unknown, so it is resolved with
+// every step.
+func (e *escalation) resolve(ctx context.Context, incident notify.Incident) {
+	e.mu.Lock()
+	last := len(e.steps) - 1
+	state, ok := e.incidents[incident.Key]
+	if ok {
+		if state.busy {
+			e.mu.Unlock()
+			return
+		}
+		state.busy = true
+		last = state.step
+	}
+	e.mu.Unlock()
+
+	resolved := true
+	for _, step := range e.steps[:last+1] {
+		if err := step.notifier.Resolve(ctx, incident); err != nil {
+			notifierErrors.WithLabelValues(step.Notifier).Inc()
+			e.logf("Error resolving %s with %s: %s", incident.Key, step.Notifier, err)
+			resolved = false
+		}
+	}
+
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state.busy = false
+	if resolved {
+		delete(e.incidents, incident.Key)
+		incidentEscalationStep.DeleteLabelValues(incident.Key)
+	}
+}
+
+func (e *escalation) status() []EscalationStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var statuses []EscalationStatus
+	for key, state := range e.incidents {
+		statuses = append(statuses, EscalationStatus{
+			Key:   key,
+			Step:  state.step,
+			Steps: append([]StepStatus(nil), state.steps...),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
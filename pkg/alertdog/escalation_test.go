@@ -0,0 +1,226 @@
+package alertdog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+type AcknowledgerMock struct {
+	NotifierMock
+}
+
+func (a *AcknowledgerMock) Acknowledged(ctx context.Context, incident notify.Incident) (bool, error) {
+	args := a.Called(incident)
+	return args.Bool(0), args.Error(1)
+}
+
+func TestEscalationOnFailure(t *testing.T) {
+	primary, secondary := &NotifierMock{}, &NotifierMock{}
+	clock := newFakeClock()
+	alertdog := New(Config{
+		Expiry: 5 * time.Minute,
+		Escalation: []EscalationStep{
+			{Notifier: "notifier 0"},
+			{Notifier: "notifier 1"},
+		},
+	}, WithClock(clock), WithNotifiers(primary, secondary), WithAlertmanager(&AlertmanagerMock{}))
+	escalated := testutil.ToFloat64(escalations.WithLabelValues("notifier 0", "failed"))
+
+	// The primary fails, so the incident is escalated to the secondary
+	primary.On("Trigger", mock.Anything).Return(errors.New("pagerduty is down")).Once()
+	secondary.On("Trigger", mock.Anything).Return(nil).Once()
+	alertdog.Check()
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+	require.Equal(t, escalated+1, testutil.ToFloat64(escalations.WithLabelValues("notifier 0", "failed")))
+	require.Equal(t, float64(1), testutil.ToFloat64(incidentEscalationStep.WithLabelValues("alertdog:webhook-expiry")))
+
+	status := alertdog.Status().Escalations
+	require.Len(t, status, 1)
+	require.Equal(t, "alertdog:webhook-expiry", status[0].Key)
+	require.Equal(t, 1, status[0].Step)
+	require.Equal(t, "pagerduty is down", status[0].Steps[0].Error)
+	require.Equal(t, clock.Now(), status[0].Steps[1].Triggered)
+
+	// It stays with the secondary, which isn't triggered again
+	clock.Advance(time.Minute)
+	alertdog.Check()
+	primary.AssertNumberOfCalls(t, "Trigger", 1)
+	secondary.AssertNumberOfCalls(t, "Trigger", 1)
+
+	// Only the steps it was escalated to are resolved
+	primary.On("Resolve", mock.Anything).Return(nil).Once()
+	secondary.On("Resolve", mock.Anything).Return(nil).Once()
+	alertdog.CheckIn()
+	alertdog.Check()
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+	require.Empty(t, alertdog.Status().Escalations)
+}
+
+func TestEscalationOnTimeout(t *testing.T) {
+	tests := []struct {
+		description  string
+		acknowledged bool
+		escalated    bool
+	}{
+		{description: "Unacknowledged incidents are escalated after the timeout", escalated: true},
+		{description: "Acknowledged incidents are not escalated", acknowledged: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			primary, secondary, tertiary := &AcknowledgerMock{}, &NotifierMock{}, &NotifierMock{}
+			clock := newFakeClock()
+			alertdog := New(Config{
+				Expiry: 5 * time.Minute,
+				Escalation: []EscalationStep{
+					{Notifier: "notifier 0", Timeout: 10 * time.Minute},
+					{Notifier: "notifier 1"},
+					{Notifier: "notifier 2"},
+				},
+			}, WithClock(clock), WithNotifiers(primary, secondary, tertiary), WithAlertmanager(&AlertmanagerMock{}))
+
+			primary.On("Trigger", mock.Anything).Return(nil).Once()
+			alertdog.Check()
+
+			// Not escalated before the timeout
+			clock.Advance(9 * time.Minute)
+			alertdog.Check()
+
+			primary.On("Acknowledged", mock.Anything).Return(test.acknowledged, nil).Once()
+			if test.escalated {
+				secondary.On("Trigger", mock.Anything).Return(nil).Once()
+			}
+			clock.Advance(time.Minute)
+			alertdog.Check()
+
+			primary.AssertExpectations(t)
+			secondary.AssertExpectations(t)
+			tertiary.AssertExpectations(t)
+			status := alertdog.Status().Escalations[0]
+			require.Equal(t, test.acknowledged, status.Steps[0].Acknowledged)
+			if test.escalated {
+				require.Equal(t, 1, status.Step)
+			} else {
+				require.Equal(t, 0, status.Step)
+			}
+		})
+	}
+}
+
+func TestEscalationWithNotifiers(t *testing.T) {
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+expiry: 5m
+notifiers:
+  - name: primary
+    pagerduty:
+      routing_key: key
+  - slack:
+      webhook_url: https://hooks.slack.com/services/T0/B0/XXX
+escalation:
+  - notifier: primary
+  - notifier: slack
+`), &config))
+	primary, secondary := &NotifierMock{}, &NotifierMock{}
+	clock := newFakeClock()
+	alertdog := New(config, WithClock(clock), WithNotifiers(primary, secondary), WithAlertmanager(&AlertmanagerMock{}))
+	require.Len(t, alertdog.escalation.steps, 2)
+
+	// The notifiers replace those in the config, so the escalation still applies
+	primary.On("Trigger", mock.Anything).Return(errors.New("pagerduty is down")).Once()
+	secondary.On("Trigger", mock.Anything).Return(nil).Once()
+	alertdog.Check()
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+
+	primary.On("Resolve", mock.Anything).Return(nil).Once()
+	secondary.On("Resolve", mock.Anything).Return(nil).Once()
+	alertdog.CheckIn()
+	alertdog.Check()
+	require.Empty(t, alertdog.Status().Escalations)
+
+	// Once resolved, the next incident starts at the first step again
+	primary.On("Trigger", mock.Anything).Return(nil).Once()
+	clock.Advance(6 * time.Minute)
+	alertdog.Check()
+	primary.AssertExpectations(t)
+	secondary.AssertNumberOfCalls(t, "Trigger", 1)
+}
+
+func TestEscalationConfig(t *testing.T) {
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+notifiers:
+  - opsgenie:
+      api_key_file: /etc/alertdog/opsgenie
+  - slack:
+      webhook_url: https://hooks.slack.com/services/T0/B0/XXX
+escalation:
+  - notifier: opsgenie
+    timeout: 15m
+  - notifier: slack
+`), &config))
+	require.Equal(t, []EscalationStep{
+		{Notifier: "opsgenie", Timeout: 15 * time.Minute},
+		{Notifier: "slack"},
+	}, config.Escalation)
+	alertdog := New(config)
+	require.Len(t, alertdog.escalation.steps, 2)
+
+	require.EqualError(t, yaml.Unmarshal([]byte(`
+escalation:
+  - notifier: slack
+`), &Config{}), `escalation: no notifier named "slack"`)
+
+	require.EqualError(t, yaml.Unmarshal([]byte(`
+notifiers:
+  - pagerduty:
+      routing_key: one
+  - pagerduty:
+      routing_key: two
+escalation:
+  - notifier: pagerduty
+`), &Config{}), `escalation: more than one notifier named "pagerduty"`)
+
+	// PagerDuty can't tell alertdog that an incident was acknowledged, so it
+	// would always be escalated after the timeout
+	require.EqualError(t, yaml.Unmarshal([]byte(`
+notifiers:
+  - pagerduty:
+      routing_key: key
+  - slack:
+      webhook_url: https://hooks.slack.com/services/T0/B0/XXX
+escalation:
+  - notifier: pagerduty
+    timeout: 15m
+  - notifier: slack
+`), &Config{}), `escalation: "pagerduty" can't tell if an incident was acknowledged, so its step can't have a timeout`)
+
+	// Notifiers passed to WithNotifiers that don't match the policy are an
+	// error, rather than every notifier being paged
+	alertdog = New(Config{
+		Escalation: []EscalationStep{{Notifier: "pagerduty"}},
+	}, WithNotifiers(newNotifierMock()))
+	require.Nil(t, alertdog.escalation)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	require.EqualError(t, alertdog.Serve(context.Background(), listener), `escalation: no notifier named "pagerduty"`)
+
+	alertdog = New(Config{
+		Escalation: []EscalationStep{{Notifier: "notifier 0", Timeout: time.Minute}},
+	}, WithNotifiers(newNotifierMock()))
+	require.Nil(t, alertdog.escalation)
+	require.Error(t, alertdog.escalationErr)
+}
@@ -0,0 +1,173 @@
+package alertdog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+// The alertmanager clusters that alerts are delivered to
+const (
+	clusterPrimary   = "primary"
+	clusterSecondary = "secondary"
+)
+
+// failover pushes alerts to the primary alertmanager cluster, or to the
+// secondary cluster when every primary endpoint fails. It records which
+// clusters each alert was delivered to, so that it is resolved there.
+type failover struct {
+	primary   Alertmanager
+	secondary Alertmanager
+	logf      func(format string, v ...interface{})
+
+	mu        sync.Mutex
+	delivered map[string]map[string]bool
+}
+
+func newFailover(primary, secondary Alertmanager, logf func(format string, v ...interface{})) *failover {
+	return &failover{
+		primary:   primary,
+		secondary: secondary,
+		logf:      logf,
+		delivered: map[string]map[string]bool{},
+	}
+}
+
+func (f *failover) cluster(name string) Alertmanager {
+	if name == clusterSecondary {
+		return f.secondary
+	}
+	return f.primary
+}
+
+// Alert pushes alert to the primary cluster, or the secondary if that fails
+func (f *failover) Alert(alert alertmanager.Alert) error {
+	cluster, err := f.push(alert, Alertmanager.Alert)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := alertKey(alert)
+	if f.delivered[key] == nil {
+		f.delivered[key] = map[string]bool{}
+	}
+	f.delivered[key][cluster] = true
+	return nil
+}
+
+// Resolve resolves alert in every cluster it was delivered to. If it isn't
+// known where alert was delivered, it is resolved like it would be delivered.
+func (f *failover) Resolve(alert alertmanager.Alert) error {
+	key := alertKey(alert)
+	f.mu.Lock()
+	clusters := f.clusters(key)
+	f.mu.Unlock()
+	if len(clusters) == 0 {
+		_, err := f.push(alert, Alertmanager.Resolve)
+		return err
+	}
+	var errs []error
+	for _, cluster := range clusters {
+		if err := f.cluster(cluster).Resolve(alert); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		alertmanagerDeliveries.WithLabelValues(cluster).Inc()
+		f.mu.Lock()
+		delete(f.delivered[key], cluster)
+		if len(f.delivered[key]) == 0 {
+			delete(f.delivered, key)
+		}
+		f.mu.Unlock()
+	}
+	return mergePushErrors(errs...)
+}
+
+// push sends alert to the primary cluster, then to the secondary if that
+// fails, returning the cluster that it was delivered to
+func (f *failover) push(alert alertmanager.Alert, send func(Alertmanager, alertmanager.Alert) error) (string, error) {
+	err := send(f.primary, alert)
+	if err == nil {
+		alertmanagerDeliveries.WithLabelValues(clusterPrimary).Inc()
+		return clusterPrimary, nil
+	}
+	if secondaryErr := send(f.secondary, alert); secondaryErr != nil {
+		return "", mergePushErrors(err, secondaryErr)
+	}
+	f.logf("Alert %s delivered to the secondary alertmanager cluster, the primary failed: %s", alertKey(alert), err)
+	alertmanagerDeliveries.WithLabelValues(clusterSecondary).Inc()
+	return clusterSecondary, nil
+}
+
+// clusters returns the clusters that the alert with key was delivered to.
+// Must be called with f.mu held.
+func (f *failover) clusters(key string) []string {
+	var clusters []string
+	for cluster := range f.delivered[key] {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// DeliveryStatus is where a firing alert was delivered
+type DeliveryStatus struct {
+	Alert    string   `json:"alert"`
+	Clusters []string `json:"clusters"`
+}
+
+func (f *failover) status() []DeliveryStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var statuses []DeliveryStatus
+	for key := range f.delivered {
+		statuses = append(statuses, DeliveryStatus{Alert: key, Clusters: f.clusters(key)})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Alert < statuses[j].Alert })
+	return statuses
+}
+
+// restore records where alerts were delivered, from a saved status
+func (f *failover) restore(deliveries []DeliveryStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, delivery := range deliveries {
+		f.delivered[delivery.Alert] = map[string]bool{}
+		for _, cluster := range delivery.Clusters {
+			f.delivered[delivery.Alert][cluster] = true
+		}
+	}
+}
+
+// mergePushErrors returns a single error for errs, the endpoints of any
+// *alertmanager.PushError are merged together
+func mergePushErrors(errs ...error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	merged := &alertmanager.PushError{Errors: map[string]error{}}
+	for _, err := range errs {
+		pushError, ok := err.(*alertmanager.PushError)
+		if !ok {
+			return errs[0]
+		}
+		for endpoint, err := range pushError.Errors {
+			merged.Errors[endpoint] = err
+		}
+	}
+	return merged
+}
+
+// alertKey identifies alert by its name and labels
+func alertKey(alert alertmanager.Alert) string {
+	pairs := make([]string, 0, len(alert.Labels))
+	for name, value := range alert.Labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s{%s}", alert.Name, strings.Join(pairs, ","))
+}
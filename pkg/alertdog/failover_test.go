@@ -0,0 +1,147 @@
+package alertdog
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+func TestFailover(t *testing.T) {
+	alert := alertmanager.Alert{Name: "PrometheusAlertFailure", Labels: map[string]string{"owner": "team-a"}}
+	primaryDown := &alertmanager.PushError{Errors: map[string]error{"http://primary:9093": errors.New("connection refused")}}
+	secondaryDown := &alertmanager.PushError{Errors: map[string]error{"http://secondary:9093": errors.New("connection refused")}}
+
+	steps := []struct {
+		description string
+		method      string
+		primary     []error
+		secondary   []error
+		err         error
+		delivered   []DeliveryStatus
+	}{
+		{
+			description: "Alerts are delivered to the primary",
+			method:      "Alert",
+			primary:     []error{nil},
+			delivered:   []DeliveryStatus{{Alert: `PrometheusAlertFailure{owner="team-a"}`, Clusters: []string{"primary"}}},
+		},
+		{
+			description: "Resolves go to the primary, where the alert was delivered",
+			method:      "Resolve",
+			primary:     []error{nil},
+		},
+		{
+			description: "Alerts are delivered to the secondary when the primary fails",
+			method:      "Alert",
+			primary:     []error{primaryDown},
+			secondary:   []error{nil},
+			delivered:   []DeliveryStatus{{Alert: `PrometheusAlertFailure{owner="team-a"}`, Clusters: []string{"secondary"}}},
+		},
+		{
+			description: "Once the primary recovers the alert is delivered there too",
+			method:      "Alert",
+			primary:     []error{nil},
+			delivered:   []DeliveryStatus{{Alert: `PrometheusAlertFailure{owner="team-a"}`, Clusters: []string{"primary", "secondary"}}},
+		},
+		{
+			description: "A failed resolve is kept, so it is retried where it failed",
+			method:      "Resolve",
+			primary:     []error{nil},
+			secondary:   []error{secondaryDown},
+			err:         secondaryDown,
+			delivered:   []DeliveryStatus{{Alert: `PrometheusAlertFailure{owner="team-a"}`, Clusters: []string{"secondary"}}},
+		},
+		{
+			description: "Resolves go to the secondary, where the alert was delivered",
+			method:      "Resolve",
+			secondary:   []error{nil},
+		},
+		{
+			description: "When both clusters fail, the errors of each are returned",
+			method:      "Alert",
+			primary:     []error{primaryDown},
+			secondary:   []error{secondaryDown},
+			err: &alertmanager.PushError{Errors: map[string]error{
+				"http://primary:9093":   primaryDown.Errors["http://primary:9093"],
+				"http://secondary:9093": secondaryDown.Errors["http://secondary:9093"],
+			}},
+		},
+	}
+
+	primary := &AlertmanagerMock{}
+	secondary := &AlertmanagerMock{}
+	f := newFailover(primary, secondary, log.Printf)
+	for _, step := range steps {
+		t.Run(step.description, func(t *testing.T) {
+			primary.ExpectedCalls, primary.Calls = nil, nil
+			secondary.ExpectedCalls, secondary.Calls = nil, nil
+			for _, err := range step.primary {
+				primary.On(step.method, alert).Return(err).Once()
+			}
+			for _, err := range step.secondary {
+				secondary.On(step.method, alert).Return(err).Once()
+			}
+			var err error
+			if step.method == "Alert" {
+				err = f.Alert(alert)
+			} else {
+				err = f.Resolve(alert)
+			}
+			require.Equal(t, step.err, err)
+			primary.AssertExpectations(t)
+			secondary.AssertExpectations(t)
+			require.Equal(t, step.delivered, f.status())
+		})
+	}
+}
+
+func TestFailoverIncident(t *testing.T) {
+	alert := alertmanager.Alert{Name: "PrometheusAlertFailure"}
+	primary := &AlertmanagerMock{}
+	secondary := &AlertmanagerMock{}
+	notifierMock := newNotifierMock()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	newAlertdog := func() *Alertdog {
+		return New(Config{
+			Expected: []*Prometheus{
+				{MatchLabels: map[string]string{"alertname": "Watchdog"}, Alert: alert, Expiry: time.Minute},
+			},
+			StateFile: stateFile,
+		}, WithClock(newFakeClock()), WithAlertmanager(primary), WithSecondaryAlertmanager(secondary), WithNotifiers(notifierMock))
+	}
+	alertdog := newAlertdog()
+	pushIncident := mock.MatchedBy(func(incident notify.Incident) bool {
+		return incident.Type == notify.TypeAlertmanagerPush
+	})
+
+	// The secondary delivered the alert, so no one is paged
+	primary.On("Alert", alert).Return(errors.New("primary is down")).Once()
+	secondary.On("Alert", alert).Return(nil).Once()
+	alertdog.act(alertdog.Expected[0], alertdog.Expected[0].Check())
+	notifierMock.AssertNotCalled(t, "Trigger", pushIncident)
+	require.Equal(t, []DeliveryStatus{{Alert: "PrometheusAlertFailure{}", Clusters: []string{"secondary"}}}, alertdog.Status().Deliveries)
+
+	// A restarted alertdog resolves the alert where it was delivered
+	require.NoError(t, alertdog.saveState())
+	restarted := newAlertdog()
+	require.NoError(t, restarted.loadState())
+	secondary.On("Resolve", alert).Return(nil).Once()
+	require.NoError(t, restarted.alertmanager.Resolve(alert))
+	primary.AssertExpectations(t)
+	secondary.AssertExpectations(t)
+
+	// Both clusters failed, so the incident is raised
+	primary.On("Alert", alert).Return(errors.New("primary is down")).Once()
+	secondary.On("Alert", alert).Return(errors.New("secondary is down")).Once()
+	notifierMock.On("Trigger", pushIncident).Return(nil).Once()
+	alertdog.act(alertdog.Expected[0], alertdog.Expected[0].Check())
+	notifierMock.AssertExpectations(t)
+}
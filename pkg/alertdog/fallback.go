@@ -0,0 +1,69 @@
+package alertdog
+
+import (
+	"fmt"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+// newFallbackNotifier returns the notifier for a prometheus' fallback, its
+// name defaults to the type of notifier prefixed with fallback-
+func newFallbackNotifier(config NotifierConfig) *trackedNotifier {
+	tracked := config.tracked()
+	if config.Name == "" {
+		tracked.name = "fallback-" + tracked.name
+	}
+	return tracked
+}
+
+// fallback delivers prometheus' alert with its fallback notifier when
+// pushing action to alertmanager failed with err. The incident is resolved
+// once the alert is pushed to alertmanager, or when it is resolved.
+func (a *Alertdog) fallback(prometheus *Prometheus, action AlertAction, err error) {
+	incident := a.fallbackIncident(prometheus, prometheus.alertFor(action), err)
+	notifier := prometheus.fallback
+	switch action {
+	case ActionAlert, ActionAlertDegraded, ActionAlertFlapping:
+		if err != nil {
+			a.logger.Println("Delivering alert directly: ", incident.Summary)
+			if err := notifier.Trigger(a.notifyCtx, incident); err != nil {
+				notifierErrors.WithLabelValues(notifier.name).Inc()
+				a.logger.Printf("Error triggering %s with %s: %s", incident.Key, notifier.name, err)
+			}
+			return
+		}
+	}
+	if err := notifier.Resolve(a.notifyCtx, incident); err != nil {
+		notifierErrors.WithLabelValues(notifier.name).Inc()
+		a.logger.Printf("Error resolving %s with %s: %s", incident.Key, notifier.name, err)
+	}
+}
+
+// fallbackIncident is alert, as delivered by a fallback notifier. Its key
+// identifies the alert by its name and labels.
+func (a *Alertdog) fallbackIncident(prometheus *Prometheus, alert alertmanager.Alert, err error) notify.Incident {
+	incident := a.alertmanagerPushIncident(prometheus, err)
+	incident.Key = fmt.Sprintf("%s:%s", incident.Key, alertKey(alert))
+	incident.Summary = fmt.Sprintf("%s: alertdog cannot push this alert to alertmanager", alert.Name)
+	if a.Instance != "" {
+		incident.Summary = fmt.Sprintf("[%s] %s", a.Instance, incident.Summary)
+	}
+	switch severity := alert.Labels["severity"]; severity {
+	case notify.SeverityCritical, notify.SeverityError, notify.SeverityWarning, notify.SeverityInfo:
+		incident.Severity = severity
+	}
+	details := map[string]string{}
+	for key, value := range incident.Details {
+		details[key] = value
+	}
+	for key, value := range alert.Labels {
+		details[key] = value
+	}
+	for key, value := range alert.Annotations {
+		details[key] = value
+	}
+	details["alertname"] = alert.Name
+	incident.Details = details
+	return incident
+}
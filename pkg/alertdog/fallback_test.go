@@ -0,0 +1,207 @@
+package alertdog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+func TestFallback(t *testing.T) {
+	alert := alertmanager.Alert{
+		Name:        "PrometheusAlertFailure",
+		Labels:      map[string]string{"owner": "team-a", "severity": "warning"},
+		Annotations: map[string]string{"runbook": "https://example.org/runbook"},
+	}
+	fallbackMock := &NotifierMock{}
+	prometheus := &Prometheus{
+		MatchLabels: map[string]string{"alertname": "Watchdog", "owner": "team-a"},
+		Expiry:      time.Minute,
+		Alert:       alert,
+		fallback:    newTrackedNotifier("team-a", fallbackMock, 0),
+	}
+	alertmanagerMock := &AlertmanagerMock{}
+	notifierMock := &NotifierMock{}
+	notifierMock.On("Trigger", mock.Anything).Return(nil)
+	notifierMock.On("Resolve", mock.Anything).Return(nil)
+	clock := newFakeClock()
+	alertdog := New(Config{
+		Expected:              []*Prometheus{prometheus},
+		AlertmanagerEndpoints: []string{"http://alertmanager:9093"},
+		Instance:              "production",
+	}, WithClock(clock), WithNotifiers(notifierMock), WithAlertmanager(alertmanagerMock))
+
+	key := `alertdog:production:alertmanager-push:PrometheusAlertFailure{owner="team-a",severity="warning"}`
+	alertmanagerMock.On("Alert", alert).Return(errors.New("alertmanager is down")).Once()
+	fallbackMock.On("Trigger", notify.Incident{
+		Key:      key,
+		Type:     notify.TypeAlertmanagerPush,
+		Summary:  "[production] PrometheusAlertFailure: alertdog cannot push this alert to alertmanager",
+		Severity: notify.SeverityWarning,
+		Details: map[string]string{
+			"instance":  "production",
+			"alertname": "PrometheusAlertFailure",
+			"owner":     "team-a",
+			"severity":  "warning",
+			"runbook":   "https://example.org/runbook",
+		},
+		Images: []notify.Image{
+			{Src: "https://github.com/errm/alertdog/raw/main/docs/dog.jpg"},
+		},
+		Context: notify.Context{
+			AlertmanagerEndpoints: []string{"http://alertmanager:9093"},
+			FailingEndpoints:      []string{"http://alertmanager:9093"},
+			AffectedTargets:       []map[string]string{prometheus.MatchLabels},
+		},
+	}).Return(nil).Once()
+	alertdog.Check()
+	alertmanagerMock.AssertExpectations(t)
+	fallbackMock.AssertExpectations(t)
+
+	// The alert is delivered through alertmanager again
+	alertmanagerMock.On("Alert", alert).Return(nil).Once()
+	fallbackMock.On("Resolve", mock.MatchedBy(func(incident notify.Incident) bool {
+		return incident.Key == key && incident.Context.FailingEndpoints == nil
+	})).Return(nil).Once()
+	clock.Advance(time.Minute)
+	alertdog.Check()
+	alertmanagerMock.AssertExpectations(t)
+	fallbackMock.AssertExpectations(t)
+
+	// The fallback is only resolved once
+	alertmanagerMock.On("Alert", alert).Return(nil).Once()
+	clock.Advance(time.Minute)
+	alertdog.Check()
+	alertmanagerMock.AssertExpectations(t)
+	fallbackMock.AssertNumberOfCalls(t, "Resolve", 1)
+}
+
+func TestFallbackResolvedWhenPushFails(t *testing.T) {
+	alert := alertmanager.Alert{Name: "PrometheusAlertFailure"}
+	fallbackMock := &NotifierMock{}
+	prometheus := &Prometheus{
+		MatchLabels: map[string]string{"alertname": "Watchdog"},
+		Expiry:      time.Minute,
+		Alert:       alert,
+		fallback:    newTrackedNotifier("fallback", fallbackMock, 0),
+	}
+	alertmanagerMock := &AlertmanagerMock{}
+	alertmanagerMock.On("Alert", alert).Return(errors.New("alertmanager is down"))
+	alertmanagerMock.On("Resolve", alert).Return(errors.New("alertmanager is down"))
+	notifierMock := &NotifierMock{}
+	notifierMock.On("Trigger", mock.Anything).Return(nil)
+	notifierMock.On("Resolve", mock.Anything).Return(nil)
+	alertdog := New(Config{Expected: []*Prometheus{prometheus}}, WithClock(newFakeClock()), WithNotifiers(notifierMock), WithAlertmanager(alertmanagerMock))
+
+	key := "alertdog:alertmanager-push:PrometheusAlertFailure{}"
+	matchKey := mock.MatchedBy(func(incident notify.Incident) bool { return incident.Key == key })
+	fallbackMock.On("Trigger", matchKey).Return(nil).Once()
+	alertdog.Check()
+	fallbackMock.AssertExpectations(t)
+
+	// The prometheus recovers while alertmanager is still down, so the resolve
+	// is delivered directly too
+	fallbackMock.On("Resolve", matchKey).Return(nil).Once()
+	for i := 0; i < 2; i++ {
+		alertdog.processWatchdog(alertdog.newDelivery(""), template.Alert{
+			Status: "firing",
+			Labels: template.KV{"alertname": "Watchdog"},
+		})
+	}
+	fallbackMock.AssertExpectations(t)
+}
+
+func TestFallbackOnlyResolvesTriggered(t *testing.T) {
+	alert := alertmanager.Alert{Name: "PrometheusAlertFailure"}
+	alertmanagerMock := &AlertmanagerMock{}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	notifierMock := &NotifierMock{}
+	notifierMock.On("Trigger", mock.Anything).Return(nil)
+	notifierMock.On("Resolve", mock.Anything).Return(nil)
+	clock := newFakeClock()
+	newAlertdog := func(fallbackMock *NotifierMock) *Alertdog {
+		return New(Config{
+			Expected: []*Prometheus{{
+				MatchLabels: map[string]string{"alertname": "Watchdog"},
+				Expiry:      time.Minute,
+				Alert:       alert,
+				fallback:    newTrackedNotifier("fallback", fallbackMock, 0),
+			}},
+			StateFile: stateFile,
+		}, WithClock(clock), WithNotifiers(notifierMock), WithAlertmanager(alertmanagerMock))
+	}
+
+	// A fresh alertdog hasn't delivered the alert directly, so there is
+	// nothing to resolve when it is pushed
+	fallbackMock := &NotifierMock{}
+	alertdog := newAlertdog(fallbackMock)
+	alertmanagerMock.On("Alert", alert).Return(nil).Once()
+	alertdog.Check()
+	fallbackMock.AssertNotCalled(t, "Resolve", mock.Anything)
+
+	// An alert delivered directly before a restart is resolved after it
+	alertmanagerMock.On("Alert", alert).Return(errors.New("alertmanager is down")).Once()
+	fallbackMock.On("Trigger", mock.Anything).Return(nil).Once()
+	clock.Advance(time.Minute)
+	alertdog.Check()
+	fallbackMock.AssertExpectations(t)
+	require.NoError(t, alertdog.saveState())
+
+	restartedMock := &NotifierMock{}
+	restarted := newAlertdog(restartedMock)
+	require.NoError(t, restarted.loadState())
+	alertmanagerMock.On("Alert", alert).Return(nil).Once()
+	restartedMock.On("Resolve", mock.MatchedBy(func(incident notify.Incident) bool {
+		return incident.Key == "alertdog:alertmanager-push:PrometheusAlertFailure{}"
+	})).Return(nil).Once()
+	clock.Advance(time.Minute)
+	restarted.Check()
+	restartedMock.AssertExpectations(t)
+	alertmanagerMock.AssertExpectations(t)
+}
+
+func TestFallbackConfig(t *testing.T) {
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+expected:
+  - match_labels:
+      alertname: Watchdog
+      owner: team-a
+    fallback:
+      pagerduty:
+        routing_key: TEAM_A_KEY
+  - match_labels:
+      alertname: Watchdog
+      owner: team-b
+    fallback:
+      name: team-b
+      slack:
+        webhook_url: https://hooks.slack.com/services/T0000/B0000/XXXX
+  - match_labels:
+      alertname: Watchdog
+      owner: team-c
+`), &config))
+	alertdog := New(config, WithAlertmanager(&AlertmanagerMock{}))
+
+	teamA := alertdog.Expected[0].fallback
+	require.Equal(t, "fallback-pagerduty", teamA.name)
+	require.Equal(t, "TEAM_A_KEY", teamA.Notifier.(*notify.PagerDuty).RoutingKey)
+	require.Equal(t, "team-b", alertdog.Expected[1].fallback.name)
+	require.IsType(t, &notify.Slack{}, alertdog.Expected[1].fallback.Notifier)
+	require.Nil(t, alertdog.Expected[2].fallback)
+
+	require.Error(t, yaml.Unmarshal([]byte(`
+expected:
+  - match_labels:
+      alertname: Watchdog
+    fallback: {}
+`), &Config{}))
+}
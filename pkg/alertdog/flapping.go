@@ -0,0 +1,61 @@
+package alertdog
+
+import (
+	"time"
+)
+
+// flapped applies flap detection to an action for Alert.
+// While flapping, alerts and resolves are replaced by FlapAlert,
+// which is held until the state is stable again.
+// Must be called with p.mu held.
+func (p *Prometheus) flapped(action AlertAction, changed bool) AlertAction {
+	if flapAction := p.updateFlapping(changed); flapAction != ActionNone {
+		return flapAction
+	}
+	if p.flapping {
+		return ActionAlertFlapping
+	}
+	return action
+}
+
+// updateFlapping records a change of state, and starts or stops flapping
+// when the flap score crosses the configured thresholds.
+func (p *Prometheus) updateFlapping(changed bool) AlertAction {
+	if p.FlapWindow <= 0 {
+		return ActionNone
+	}
+	now := p.now()
+	if changed {
+		p.stateChanges = append(p.stateChanges, now)
+	}
+	score := p.flapScore(now)
+	switch {
+	case !p.flapping && score >= p.FlapHighThreshold:
+		p.flapping = true
+		return ActionAlertFlapping
+	case p.flapping && score < p.FlapLowThreshold:
+		p.flapping = false
+		return ActionResolveFlapping
+	}
+	return ActionNone
+}
+
+// flapScore is the number of state changes within FlapWindow,
+// like nagios recent changes are weighted more heavily than older ones,
+// from 1.2 for a change that just happened to 0.8 at the end of the window.
+func (p *Prometheus) flapScore(now time.Time) float64 {
+	var (
+		score  float64
+		recent = p.stateChanges[:0]
+	)
+	for _, change := range p.stateChanges {
+		age := now.Sub(change)
+		if age > p.FlapWindow {
+			continue
+		}
+		recent = append(recent, change)
+		score += 0.8 + 0.4*(1-float64(age)/float64(p.FlapWindow))
+	}
+	p.stateChanges = recent
+	return score
+}
@@ -0,0 +1,294 @@
+package alertdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+// NotifierConfig configures a notifier that alertdog's own incidents are raised with
+type NotifierConfig struct {
+	// Used in logs (optional) (defaults to the type of notifier)
+	Name string
+	// An open incident is triggered again this often, in case it was
+	// resolved by hand (optional) (by default it is only triggered once)
+	ReassertInterval time.Duration           `yaml:"reassert_interval"`
+	PagerDuty        *notify.PagerDutyConfig `yaml:"pagerduty"`
+	Slack            *notify.SlackConfig     `yaml:"slack"`
+	Opsgenie         *notify.OpsgenieConfig  `yaml:"opsgenie"`
+	Email            *notify.EmailConfig     `yaml:"email"`
+	Webhook          *notify.WebhookConfig   `yaml:"webhook"`
+}
+
+func (c *NotifierConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain NotifierConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	types := 0
+	for _, set := range []bool{c.PagerDuty != nil, c.Slack != nil, c.Opsgenie != nil, c.Email != nil, c.Webhook != nil} {
+		if set {
+			types++
+		}
+	}
+	if types != 1 {
+		return errors.New("notifier must have one type, e.g. pagerduty, slack, opsgenie, email or webhook")
+	}
+	return nil
+}
+
+func (c NotifierConfig) notifier() (string, notify.Notifier) {
+	var (
+		name     string
+		notifier notify.Notifier
+	)
+	switch {
+	case c.PagerDuty != nil:
+		name, notifier = "pagerduty", notify.NewPagerDuty(*c.PagerDuty)
+	case c.Slack != nil:
+		name, notifier = "slack", notify.NewSlack(*c.Slack)
+	case c.Opsgenie != nil:
+		name, notifier = "opsgenie", notify.NewOpsgenie(*c.Opsgenie)
+	case c.Email != nil:
+		name, notifier = "email", notify.NewEmail(*c.Email)
+	case c.Webhook != nil:
+		name, notifier = "webhook", notify.NewWebhook(*c.Webhook)
+	}
+	if c.Name != "" {
+		name = c.Name
+	}
+	return name, notifier
+}
+
+// tracked returns the notifier for c, tracking the incidents sent to it.
+// Slack, email and webhook resolves of incidents they never triggered would
+// be a message about nothing, so they are only sent for triggered incidents.
+func (c NotifierConfig) tracked() *trackedNotifier {
+	name, notifier := c.notifier()
+	tracked := newTrackedNotifier(name, notifier, c.ReassertInterval)
+	tracked.resolveOnlyTriggered = c.Slack != nil || c.Email != nil || c.Webhook != nil
+	return tracked
+}
+
+// notifierConfigs returns the configured notifiers, or if there are none
+// a PagerDuty notifier configured by PagerDuty, using PagerDutyKey and
+// PagerDutyKeys unless it has routing keys of its own
+func (c Config) notifierConfigs() []NotifierConfig {
+	if len(c.Notifiers) > 0 {
+		return c.Notifiers
+	}
+	config := notify.PagerDutyConfig{RetryConfig: notify.RetryConfig{MaxRetries: 3}}
+	if c.PagerDuty != nil {
+		config = *c.PagerDuty
+	}
+	if config.RoutingKey == "" {
+		config.RoutingKey = c.PagerDutyKey
+	}
+	if config.RoutingKeys == nil {
+		config.RoutingKeys = c.PagerDutyKeys
+	}
+	return []NotifierConfig{{PagerDuty: &config}}
+}
+
+// trackedNotifier only sends an incident to its notifier when the incident
+// is triggered or resolved, not every time it is checked. The lock isn't held
+// while sending, an incident that is already being sent is skipped, it will
+// be sent again at the next check if it is still needed.
+type trackedNotifier struct {
+	notify.Notifier
+	name     string
+	reassert time.Duration
+	clock    Clock
+	// Don't send resolves for incidents whose state is unknown
+	resolveOnlyTriggered bool
+
+	mu        sync.Mutex
+	incidents map[string]incidentState
+	sending   map[string]bool
+}
+
+// incidentState is the last event successfully sent for an incident
+type incidentState struct {
+	triggered bool
+	sent      time.Time
+}
+
+func newTrackedNotifier(name string, notifier notify.Notifier, reassert time.Duration) *trackedNotifier {
+	return &trackedNotifier{
+		Notifier:  notifier,
+		name:      name,
+		reassert:  reassert,
+		incidents: map[string]incidentState{},
+		sending:   map[string]bool{},
+	}
+}
+
+// Trigger triggers incident, unless it is already open
+func (t *trackedNotifier) Trigger(ctx context.Context, incident notify.Incident) error {
+	return t.send(incident.Key, true, func() error {
+		return t.Notifier.Trigger(ctx, incident)
+	})
+}
+
+// Resolve resolves incident, unless it is already resolved.
+// Until an event has been sent for an incident its state is unknown, so the
+// first resolve is sent, in case the incident was left open by a restart,
+// unless resolveOnlyTriggered is set.
+func (t *trackedNotifier) Resolve(ctx context.Context, incident notify.Incident) error {
+	return t.send(incident.Key, false, func() error {
+		return t.Notifier.Resolve(ctx, incident)
+	})
+}
+
+// send calls send if the incident with key isn't already triggered or
+// resolved, and records the new state if it succeeds
+func (t *trackedNotifier) send(key string, trigger bool, send func() error) error {
+	t.mu.Lock()
+	last, ok := t.incidents[key]
+	skip := t.sending[key] || (!ok && !trigger && t.resolveOnlyTriggered)
+	if ok && last.triggered == trigger {
+		// only open incidents are sent again, after the reassert interval
+		reassert := trigger && t.reassert > 0 && t.clock.Now().Sub(last.sent) >= t.reassert
+		skip = skip || !reassert
+	}
+	if skip {
+		t.mu.Unlock()
+		return nil
+	}
+	t.sending[key] = true
+	t.mu.Unlock()
+
+	err := send()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sending, key)
+	if err != nil {
+		return err
+	}
+	t.incidents[key] = incidentState{triggered: trigger, sent: t.clock.Now()}
+	return nil
+}
+
+// triggered returns the keys of the incidents that are open, sorted
+func (t *trackedNotifier) triggered() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []string
+	for key, state := range t.incidents {
+		if state.triggered {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// restore records that the incidents with keys were left open by a previous run
+func (t *trackedNotifier) restore(keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		t.incidents[key] = incidentState{triggered: true, sent: t.clock.Now()}
+	}
+}
+
+// incident returns one of alertdog's own incidents, its key is namespaced by
+// Instance when it is set
+func (a *Alertdog) incident(incidentType, summary string) notify.Incident {
+	a.mu.RLock()
+	lastWebhook := a.checkedIn
+	a.mu.RUnlock()
+	incident := notify.Incident{
+		Key:      "alertdog:" + incidentType,
+		Type:     incidentType,
+		Summary:  summary,
+		Severity: notify.SeverityCritical,
+		Images: []notify.Image{
+			{Src: "https://github.com/errm/alertdog/raw/main/docs/dog.jpg"},
+		},
+		Context: notify.Context{
+			AlertmanagerEndpoints: a.AlertmanagerEndpoints,
+			LastWebhook:           lastWebhook,
+		},
+	}
+	if a.Instance != "" {
+		incident.Key = fmt.Sprintf("alertdog:%s:%s", a.Instance, incidentType)
+		incident.Summary = fmt.Sprintf("[%s] %s", a.Instance, summary)
+		incident.Details = map[string]string{"instance": a.Instance}
+	}
+	if a.PagerDutyRunbookURL != "" {
+		incident.Links = []notify.Link{
+			{Text: "Runbook 📕", Href: a.PagerDutyRunbookURL},
+		}
+	}
+	return incident
+}
+
+// webhookExpiryIncident is raised when no webhooks have been received, it
+// affects every expected prometheus that isn't healthy
+func (a *Alertdog) webhookExpiryIncident() notify.Incident {
+	incident := a.incident(
+		notify.TypeWebhookExpiry,
+		fmt.Sprintf("Alertdog: didn't receive webhook from alert manager for over %v", a.Expiry),
+	)
+	for _, prometheus := range a.Expected {
+		if !prometheus.Status().Healthy {
+			incident.Context.AffectedTargets = append(incident.Context.AffectedTargets, prometheus.MatchLabels)
+		}
+	}
+	return incident
+}
+
+// alertmanagerPushIncident is raised when pushing prometheus's alert failed with err
+func (a *Alertdog) alertmanagerPushIncident(prometheus *Prometheus, err error) notify.Incident {
+	incident := a.incident(notify.TypeAlertmanagerPush, "Alertdog cannot push alerts to alertmanager")
+	incident.Context.AffectedTargets = []map[string]string{prometheus.MatchLabels}
+	var pushError *alertmanager.PushError
+	if errors.As(err, &pushError) {
+		for endpoint := range pushError.Errors {
+			incident.Context.FailingEndpoints = append(incident.Context.FailingEndpoints, endpoint)
+		}
+		sort.Strings(incident.Context.FailingEndpoints)
+	} else if err != nil {
+		incident.Context.FailingEndpoints = a.AlertmanagerEndpoints
+	}
+	return incident
+}
+
+// trigger raises incident through the escalation policy, or if there isn't
+// one with every notifier
+func (a *Alertdog) trigger(incident notify.Incident) {
+	a.logger.Println("Incident: ", incident.Summary)
+	if a.escalation != nil {
+		a.escalation.trigger(a.notifyCtx, incident)
+		return
+	}
+	for _, notifier := range a.notifiers {
+		if err := notifier.Trigger(a.notifyCtx, incident); err != nil {
+			notifierErrors.WithLabelValues(notifier.name).Inc()
+			a.logger.Printf("Error triggering %s with %s: %s", incident.Key, notifier.name, err)
+		}
+	}
+}
+
+// resolve resolves incident through the escalation policy, or if there isn't
+// one with every notifier
+func (a *Alertdog) resolve(incident notify.Incident) {
+	if a.escalation != nil {
+		a.escalation.resolve(a.notifyCtx, incident)
+		return
+	}
+	for _, notifier := range a.notifiers {
+		if err := notifier.Resolve(a.notifyCtx, incident); err != nil {
+			notifierErrors.WithLabelValues(notifier.name).Inc()
+			a.logger.Printf("Error resolving %s with %s: %s", incident.Key, notifier.name, err)
+		}
+	}
+}
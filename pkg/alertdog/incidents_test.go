@@ -0,0 +1,278 @@
+package alertdog
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+func TestIncidentTransitions(t *testing.T) {
+	incident := func(key string) interface{} {
+		return mock.MatchedBy(func(incident notify.Incident) bool {
+			return incident.Key == key
+		})
+	}
+	steps := []struct {
+		description string
+		checkIn     bool
+		advance     time.Duration
+		method      string
+		err         error
+	}{
+		{description: "The state is unknown, so the incident is triggered", method: "Trigger"},
+		{description: "The incident is open, so it isn't triggered again"},
+		{description: "The incident is triggered again after the reassert interval", advance: 30 * time.Minute, method: "Trigger"},
+		{description: "A failed resolve is retried on the next check", checkIn: true, method: "Resolve", err: errors.New("rate limited")},
+		{description: "The resolve is retried", method: "Resolve"},
+		{description: "The incident is resolved, so it isn't resolved again", advance: time.Minute},
+		{description: "The incident is resolved, even after the reassert interval", advance: 30 * time.Minute, checkIn: true},
+	}
+
+	clock := newFakeClock()
+	notifierMock := &NotifierMock{}
+	alertdog := New(Config{Expiry: 5 * time.Minute}, WithClock(clock), WithNotifiers(notifierMock), WithAlertmanager(&AlertmanagerMock{}))
+	alertdog.notifiers[0].reassert = 30 * time.Minute
+
+	for _, step := range steps {
+		t.Run(step.description, func(t *testing.T) {
+			notifierMock.ExpectedCalls = nil
+			notifierMock.Calls = nil
+			if step.method != "" {
+				notifierMock.On(step.method, incident("alertdog:webhook-expiry")).Return(step.err).Once()
+			}
+			clock.Advance(step.advance)
+			if step.checkIn {
+				alertdog.CheckIn()
+			}
+			alertdog.Check()
+			notifierMock.AssertExpectations(t)
+			require.Len(t, notifierMock.Calls, len(notifierMock.ExpectedCalls))
+		})
+	}
+}
+
+func TestNotifierConfig(t *testing.T) {
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+notifiers:
+  - name: sre
+    reassert_interval: 1h
+    pagerduty:
+      routing_key: sre-key
+      url: https://events.eu.pagerduty.com/v2/enqueue
+  - slack:
+      webhook_url: https://hooks.slack.com/services/T0/B0/XXX
+  - opsgenie:
+      api_key_file: /etc/alertdog/opsgenie
+  - email:
+      smarthost: smtp.example.org:587
+      from: alertdog@example.org
+      to: [sre@example.org]
+  - webhook:
+      url: https://incidents.example.org/api
+`), &config))
+	require.Len(t, config.Notifiers, 5)
+	require.Equal(t, time.Hour, config.Notifiers[0].ReassertInterval)
+	require.Equal(t, "sre-key", config.Notifiers[0].PagerDuty.RoutingKey)
+	require.Equal(t, 3, config.Notifiers[0].PagerDuty.MaxRetries)
+
+	alertdog := New(config)
+	require.Len(t, alertdog.notifiers, 5)
+	require.Equal(t, "sre", alertdog.notifiers[0].name)
+	require.IsType(t, &notify.PagerDuty{}, alertdog.notifiers[0].Notifier)
+	require.Equal(t, "slack", alertdog.notifiers[1].name)
+	require.IsType(t, &notify.Slack{}, alertdog.notifiers[1].Notifier)
+	require.IsType(t, &notify.Opsgenie{}, alertdog.notifiers[2].Notifier)
+	require.IsType(t, &notify.Email{}, alertdog.notifiers[3].Notifier)
+	require.IsType(t, &notify.Webhook{}, alertdog.notifiers[4].Notifier)
+
+	require.Error(t, yaml.Unmarshal([]byte(`notifiers: [{name: nothing}]`), &config))
+	require.Error(t, yaml.Unmarshal([]byte(`notifiers: [{slack: {webhook_url: "https://example.org"}, opsgenie: {api_key_file: key}}]`), &config))
+	require.Error(t, yaml.Unmarshal([]byte(`notifiers: [{slack: {channel: "#sre"}}]`), &config))
+}
+
+func TestDefaultNotifier(t *testing.T) {
+	alertdog := New(Config{
+		PagerDutyKey:  "pager-duty-key",
+		PagerDutyKeys: map[string]string{notify.TypeWebhookExpiry: "webhook-key"},
+	})
+	require.Len(t, alertdog.notifiers, 1)
+	require.Equal(t, "pagerduty", alertdog.notifiers[0].name)
+	pagerDuty := alertdog.notifiers[0].Notifier.(*notify.PagerDuty)
+	require.Equal(t, "pager-duty-key", pagerDuty.RoutingKey)
+	require.Equal(t, map[string]string{"webhook-expiry": "webhook-key"}, pagerDuty.RoutingKeys)
+
+	var config Config
+	require.Error(t, yaml.Unmarshal([]byte(`pager_duty_keys: {webhook_expiry: webhook-key}`), &config))
+
+	// The top level pagerduty block configures the default notifier
+	config = Config{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+pager_duty_key: pager-duty-key
+pagerduty:
+  url: https://events.eu.pagerduty.com/v2/enqueue
+  timeout: 5s
+`), &config))
+	pagerDuty = New(config).notifiers[0].Notifier.(*notify.PagerDuty)
+	require.Equal(t, "pager-duty-key", pagerDuty.RoutingKey)
+	require.Equal(t, "https://events.eu.pagerduty.com/v2/enqueue", pagerDuty.URL)
+	require.Equal(t, 5*time.Second, pagerDuty.Timeout)
+	require.Equal(t, 3, pagerDuty.MaxRetries)
+
+	require.Error(t, yaml.Unmarshal([]byte(`
+pagerduty:
+  url: https://events.eu.pagerduty.com/v2/enqueue
+notifiers:
+  - slack:
+      webhook_url: https://hooks.slack.com/services/T0/B0/XXX
+`), &Config{}))
+}
+
+func TestIncidentInstance(t *testing.T) {
+	staging := New(Config{Instance: "staging"}, WithNotifiers(newNotifierMock()))
+	production := New(Config{Instance: "production"}, WithNotifiers(newNotifierMock()))
+
+	incident := staging.webhookExpiryIncident()
+	require.Equal(t, "alertdog:staging:webhook-expiry", incident.Key)
+	require.Equal(t, notify.TypeWebhookExpiry, incident.Type)
+	require.Equal(t, "[staging] Alertdog: didn't receive webhook from alert manager for over 5m0s", incident.Summary)
+	require.Equal(t, map[string]string{"instance": "staging"}, incident.Details)
+	require.NotEqual(t, incident.Key, production.webhookExpiryIncident().Key)
+
+	incident = New(Config{}, WithNotifiers(newNotifierMock())).alertmanagerPushIncident(&Prometheus{}, nil)
+	require.Equal(t, "alertdog:alertmanager-push", incident.Key)
+	require.Equal(t, "Alertdog cannot push alerts to alertmanager", incident.Summary)
+	require.Nil(t, incident.Details)
+}
+
+// blockingNotifier blocks triggering the alertmanager-push incident until it
+// is released
+type blockingNotifier struct {
+	entered  chan struct{}
+	release  chan struct{}
+	triggers atomic.Int64
+}
+
+func (b *blockingNotifier) Trigger(ctx context.Context, incident notify.Incident) error {
+	b.triggers.Inc()
+	if incident.Type == notify.TypeAlertmanagerPush {
+		close(b.entered)
+		<-b.release
+	}
+	return nil
+}
+
+func (b *blockingNotifier) Resolve(ctx context.Context, incident notify.Incident) error {
+	return nil
+}
+
+func TestNotifierLocks(t *testing.T) {
+	for _, escalation := range [][]EscalationStep{nil, {{Notifier: "notifier 0"}}} {
+		notifier := &blockingNotifier{entered: make(chan struct{}), release: make(chan struct{})}
+		alertdog := New(Config{Expiry: time.Minute}, WithClock(newFakeClock()), WithNotifiers(notifier), WithAlertmanager(&AlertmanagerMock{}))
+		if escalation != nil {
+			alertdog.Escalation = escalation
+			var err error
+			alertdog.escalation, err = alertdog.newEscalation()
+			require.NoError(t, err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			alertdog.trigger(alertdog.alertmanagerPushIncident(&Prometheus{}, nil))
+			close(done)
+		}()
+		<-notifier.entered
+
+		// Other incidents, and the status, aren't blocked by a slow notifier,
+		// and the incident that is being sent isn't sent twice
+		alertdog.trigger(alertdog.webhookExpiryIncident())
+		alertdog.trigger(alertdog.alertmanagerPushIncident(&Prometheus{}, nil))
+		alertdog.Status()
+		require.Equal(t, int64(2), notifier.triggers.Load())
+
+		close(notifier.release)
+		<-done
+	}
+}
+
+func TestFreshAlertdogEmailsNoResolves(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	connections := atomic.NewInt64(0)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Inc()
+			conn.Close()
+		}
+	}()
+	defer listener.Close()
+
+	alertdog := New(Config{
+		Expiry: time.Minute,
+		Notifiers: []NotifierConfig{{Email: &notify.EmailConfig{
+			Smarthost: listener.Addr().String(),
+			From:      "alertdog@example.org",
+			To:        []string{"sre@example.org"},
+		}}},
+	}, WithAlertmanager(nopAlertmanager{}))
+	alertdog.CheckIn()
+	alertdog.Check()
+	require.Equal(t, int64(0), connections.Load())
+}
+
+func TestOpenIncidentsResolvedAfterRestart(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		statuses []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		statuses = append(statuses, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	clock := newFakeClock()
+	newAlertdog := func() *Alertdog {
+		return New(Config{
+			Expiry:    time.Minute,
+			StateFile: stateFile,
+			Notifiers: []NotifierConfig{{Webhook: &notify.WebhookConfig{
+				URL:  server.URL,
+				Body: "{{ .Status }}",
+			}}},
+		}, WithClock(clock), WithAlertmanager(nopAlertmanager{}))
+	}
+
+	alertdog := newAlertdog()
+	alertdog.Check()
+	require.NoError(t, alertdog.saveState())
+	require.Equal(t, []string{"firing"}, statuses)
+
+	// The incident left open is resolved by the restarted alertdog
+	restarted := newAlertdog()
+	require.NoError(t, restarted.loadState())
+	restarted.CheckIn()
+	restarted.Check()
+	require.Equal(t, []string{"firing", "resolved"}, statuses)
+}
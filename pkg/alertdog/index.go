@@ -0,0 +1,80 @@
+package alertdog
+
+import (
+	"sort"
+)
+
+type labelPair struct {
+	name, value string
+}
+
+// labelIndex finds the expected prometheus that match a set of labels,
+// without comparing the labels against every entry.
+// Each entry is indexed by its most selective match label, so it only
+// needs to be compared against alerts that have that label.
+// A match label with an empty value matches alerts without the label, so
+// entries are never indexed by one.
+type labelIndex struct {
+	postings map[labelPair][]indexEntry
+	// entries without a non-empty match label, compared against every alert
+	all []indexEntry
+}
+
+type indexEntry struct {
+	position   int
+	prometheus *Prometheus
+}
+
+func newLabelIndex(expected []*Prometheus) *labelIndex {
+	counts := make(map[labelPair]int)
+	for _, prometheus := range expected {
+		for name, value := range prometheus.MatchLabels {
+			counts[labelPair{name, value}] += 1
+		}
+	}
+
+	index := &labelIndex{postings: make(map[labelPair][]indexEntry)}
+	for position, prometheus := range expected {
+		entry := indexEntry{position: position, prometheus: prometheus}
+		var best labelPair
+		for name, value := range prometheus.MatchLabels {
+			pair := labelPair{name, value}
+			if value == "" {
+				continue
+			}
+			if best.name == "" || counts[pair] < counts[best] || (counts[pair] == counts[best] && name < best.name) {
+				best = pair
+			}
+		}
+		if best.name == "" {
+			index.all = append(index.all, entry)
+			continue
+		}
+		index.postings[best] = append(index.postings[best], entry)
+	}
+	return index
+}
+
+// match returns the expected prometheus that match labels, in the order
+// they were configured
+func (i *labelIndex) match(labels map[string]string) []*Prometheus {
+	var entries []indexEntry
+	for _, entry := range i.all {
+		if entry.prometheus.match(labels) {
+			entries = append(entries, entry)
+		}
+	}
+	for name, value := range labels {
+		for _, entry := range i.postings[labelPair{name, value}] {
+			if entry.prometheus.match(labels) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].position < entries[b].position })
+	matches := make([]*Prometheus, len(entries))
+	for n, entry := range entries {
+		matches[n] = entry.prometheus
+	}
+	return matches
+}
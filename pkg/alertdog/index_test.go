@@ -0,0 +1,103 @@
+package alertdog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+func TestLabelIndex(t *testing.T) {
+	teamA := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog", "owner": "team-a"}}
+	teamB := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog", "owner": "team-b"}}
+	teamBEU := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog", "owner": "team-b", "region": "eu"}}
+	anyWatchdog := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog"}}
+	everything := &Prometheus{}
+	noCluster := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog", "cluster": ""}}
+	clusterX := &Prometheus{MatchLabels: map[string]string{"alertname": "Watchdog", "cluster": "x"}}
+	onlyEmpty := &Prometheus{MatchLabels: map[string]string{"cluster": ""}}
+
+	index := newLabelIndex([]*Prometheus{teamA, teamB, teamBEU, anyWatchdog, everything, noCluster, clusterX, onlyEmpty})
+
+	var tests = []struct {
+		labels   map[string]string
+		expected []*Prometheus
+	}{
+		{
+			labels:   map[string]string{"alertname": "Watchdog", "owner": "team-a"},
+			expected: []*Prometheus{teamA, anyWatchdog, everything, noCluster, onlyEmpty},
+		},
+		{
+			labels:   map[string]string{"alertname": "Watchdog", "owner": "team-b", "region": "eu"},
+			expected: []*Prometheus{teamB, teamBEU, anyWatchdog, everything, noCluster, onlyEmpty},
+		},
+		{
+			labels:   map[string]string{"alertname": "Watchdog", "owner": "team-c"},
+			expected: []*Prometheus{anyWatchdog, everything, noCluster, onlyEmpty},
+		},
+		{
+			labels:   map[string]string{"owner": "team-a"},
+			expected: []*Prometheus{everything, onlyEmpty},
+		},
+		{
+			// An empty match label matches alerts without the label
+			labels:   map[string]string{"alertname": "Watchdog"},
+			expected: []*Prometheus{anyWatchdog, everything, noCluster, onlyEmpty},
+		},
+		{
+			labels:   map[string]string{"alertname": "Watchdog", "cluster": "x"},
+			expected: []*Prometheus{anyWatchdog, everything, clusterX},
+		},
+	}
+
+	all := []*Prometheus{teamA, teamB, teamBEU, anyWatchdog, everything, noCluster, clusterX, onlyEmpty}
+	for _, test := range tests {
+		require.Equal(t, test.expected, index.match(test.labels), test.labels)
+		// The index matches the same entries as comparing against every one
+		var linear []*Prometheus
+		for _, prometheus := range all {
+			if prometheus.match(test.labels) {
+				linear = append(linear, prometheus)
+			}
+		}
+		require.Equal(t, linear, index.match(test.labels), test.labels)
+	}
+}
+
+type nopAlertmanager struct{}
+
+func (nopAlertmanager) Alert(alertmanager.Alert) error   { return nil }
+func (nopAlertmanager) Resolve(alertmanager.Alert) error { return nil }
+
+func BenchmarkProcessWatchdog(b *testing.B) {
+	for _, targets := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("targets=%d", targets), func(b *testing.B) {
+			var expected []*Prometheus
+			for i := 0; i < targets; i++ {
+				expected = append(expected, &Prometheus{
+					MatchLabels: map[string]string{
+						"alertname":  "Watchdog",
+						"prometheus": fmt.Sprintf("prom%d", i),
+					},
+				})
+			}
+			alertdog := New(Config{Expected: expected}, WithAlertmanager(nopAlertmanager{}), WithNotifiers(newNotifierMock()))
+			watchdog := template.Alert{
+				Status: "firing",
+				Labels: template.KV{
+					"alertname":  "Watchdog",
+					"prometheus": "prom1",
+				},
+			}
+			delivery := alertdog.newDelivery("")
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				alertdog.processWatchdog(delivery, watchdog)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package alertdog
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	actionQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "alertdog_action_queue_depth",
+		Help: "The number of alertmanager actions waiting to be pushed.",
+	})
+	actionQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "alertdog_action_queue_dropped_total",
+		Help: "The number of alertmanager actions dropped because the queue was full.",
+	})
+	webhookAuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alertdog_webhook_auth_failures_total",
+		Help: "The number of webhook requests rejected because they could not be authenticated.",
+	}, []string{"reason"})
+	notifierErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alertdog_notifier_errors_total",
+		Help: "The number of times a notifier failed to trigger or resolve an incident.",
+	}, []string{"notifier"})
+	escalations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alertdog_escalations_total",
+		Help: "The number of times an incident was escalated past a notifier, because it failed or the incident wasn't acknowledged.",
+	}, []string{"notifier", "reason"})
+	alertmanagerDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alertdog_alertmanager_deliveries_total",
+		Help: "The number of alerts and resolves delivered to each alertmanager cluster, primary or secondary.",
+	}, []string{"cluster"})
+	incidentEscalationStep = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alertdog_incident_escalation_step",
+		Help: "The escalation step that an open incident has reached, starting at 0.",
+	}, []string{"incident"})
+)
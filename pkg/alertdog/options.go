@@ -0,0 +1,79 @@
+package alertdog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+// Option configures an Alertdog created with New
+type Option func(*Alertdog)
+
+// WithAlertmanager replaces the alertmanager client that alerts are pushed to
+func WithAlertmanager(alertmanager Alertmanager) Option {
+	return func(a *Alertdog) {
+		a.alertmanager = alertmanager
+	}
+}
+
+// WithSecondaryAlertmanager sets the alertmanager client that alerts are
+// pushed to when pushing them to the primary alertmanager fails
+func WithSecondaryAlertmanager(alertmanager Alertmanager) Option {
+	return func(a *Alertdog) {
+		a.secondary = alertmanager
+	}
+}
+
+// WithNotifiers replaces the notifiers that alertdog's own incidents are
+// raised with. Incidents are only sent to them when they are triggered or resolved.
+// Each notifier takes the name of the notifier in config.Notifiers that it
+// replaces, so escalation steps still match it, or "notifier <index>" if
+// there are more notifiers than in the config.
+func WithNotifiers(notifiers ...notify.Notifier) Option {
+	return func(a *Alertdog) {
+		a.notifiers = []*trackedNotifier{}
+		for i, notifier := range notifiers {
+			name := fmt.Sprintf("notifier %d", i)
+			if i < len(a.Notifiers) {
+				name, _ = a.Notifiers[i].notifier()
+			}
+			a.notifiers = append(a.notifiers, newTrackedNotifier(name, notifier, 0))
+		}
+	}
+}
+
+// WithLogger replaces the logger, by default alertdog logs to stderr
+func WithLogger(logger *log.Logger) Option {
+	return func(a *Alertdog) {
+		a.logger = logger
+	}
+}
+
+// WithMux registers alertdog's /webhook endpoint on mux, rather than a mux of
+// its own. /health, /status and /metrics aren't registered on it.
+func WithMux(mux *http.ServeMux) Option {
+	return func(a *Alertdog) {
+		a.mux = mux
+	}
+}
+
+// WithClock replaces the clock used for expiry and scheduling checks
+func WithClock(clock Clock) Option {
+	return func(a *Alertdog) {
+		a.clock = clock
+	}
+}
+
+// Clock tells the time, and waits for it to pass
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
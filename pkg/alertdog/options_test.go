@@ -0,0 +1,104 @@
+package alertdog
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+func TestOptions(t *testing.T) {
+	alert := alertmanager.Alert{
+		Labels: map[string]string{
+			"alert": "one",
+		},
+	}
+
+	clock := newFakeClock()
+	alertmanagerMock := &AlertmanagerMock{}
+	var logs bytes.Buffer
+	mux := http.NewServeMux()
+	// The embedding program's own endpoints aren't replaced
+	mux.Handle("/metrics", http.NotFoundHandler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	alertdog := New(Config{
+		Expected: []*Prometheus{
+			&Prometheus{
+				MatchLabels: map[string]string{
+					"alertname":  "Watchdog",
+					"prometheus": "prom1",
+				},
+				Alert:  alert,
+				Expiry: 4 * time.Minute,
+			},
+		},
+		Expiry: 5 * time.Minute,
+	},
+		WithClock(clock),
+		WithAlertmanager(alertmanagerMock),
+		WithNotifiers(newNotifierMock()),
+		WithLogger(log.New(&logs, "", 0)),
+		WithMux(mux),
+	)
+
+	firing := template.Alert{
+		Status: "firing",
+		Labels: template.KV{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+	}
+	alertmanagerMock.On("Resolve", alert).Return(nil).Once()
+	alertdog.processWatchdog(alertdog.newDelivery(""), firing)
+	alertdog.processWatchdog(alertdog.newDelivery(""), firing)
+	alertmanagerMock.AssertExpectations(t)
+
+	// Time only passes when the clock is advanced
+	clock.Advance(4*time.Minute - time.Second)
+	require.False(t, alertdog.Expected[0].Expired())
+	clock.Advance(time.Second)
+	require.True(t, alertdog.Expected[0].Expired())
+	require.False(t, alertdog.Expired())
+
+	alertmanagerMock.On("Alert", alert).Return(nil).Once()
+	alertdog.act(alertdog.Expected[0], alertdog.Expected[0].Check())
+	alertmanagerMock.AssertExpectations(t)
+
+	// Only the webhook is registered on the mux
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusTeapot, recorder.Code)
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// Logs are written to the logger
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString("not json")))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Contains(t, logs.String(), "Webhook body invalid")
+}
+
+func TestLoggerUsedForPushErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	alertdog := New(Config{
+		AlertmanagerEndpoints: []string{server.URL},
+	}, WithNotifiers(newNotifierMock()), WithLogger(log.New(&logs, "", 0)))
+	require.Error(t, alertdog.alertmanager.Alert(alertmanager.Alert{Name: "Watchdog"}))
+	require.Contains(t, logs.String(), "Error pushing alert to "+server.URL)
+}
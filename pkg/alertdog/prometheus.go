@@ -1,6 +1,8 @@
 package alertdog
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -14,53 +16,260 @@ const (
 	ActionNone AlertAction = iota
 	ActionAlert
 	ActionResolve
+	ActionAlertDegraded
+	ActionResolveDegraded
+	ActionAlertFlapping
+	ActionResolveFlapping
 )
 
+// What to do when a resolved Watchdog is received
+const (
+	// Raise the alert straight away
+	ResolvedAlert = "alert"
+	// Treat the Watchdog as missing, the alert is raised once it expires
+	ResolvedMissing = "missing"
+	// Ignore resolved Watchdogs entirely
+	ResolvedIgnore = "ignore"
+)
+
+const defaultResolveCount = 2
+
 type Prometheus struct {
-	MatchLabels map[string]string `yaml:"match_labels"`
-	Expiry      time.Duration
-	Alert       alertmanager.Alert
-	checkedIn   time.Time
-	count       uint
-	mu          sync.RWMutex
+	MatchLabels       map[string]string `yaml:"match_labels"`
+	Expiry            time.Duration
+	CheckInterval     time.Duration `yaml:"check_interval"`
+	Alert             alertmanager.Alert
+	ReplicaLabel      string             `yaml:"replica_label"`
+	MinReplicas       int                `yaml:"min_replicas"`
+	DegradedAlert     alertmanager.Alert `yaml:"degraded_alert"`
+	ResolveCount      uint               `yaml:"resolve_count"`
+	MinHealthy        time.Duration      `yaml:"min_healthy"`
+	OnResolved        string             `yaml:"on_resolved"`
+	MinDeliveryGap    time.Duration      `yaml:"min_delivery_gap"`
+	FlapWindow        time.Duration      `yaml:"flap_window"`
+	FlapHighThreshold float64            `yaml:"flap_high_threshold"`
+	FlapLowThreshold  float64            `yaml:"flap_low_threshold"`
+	FlapAlert         alertmanager.Alert `yaml:"flap_alert"`
+	Fallback          *NotifierConfig    `yaml:"fallback"`
+	fallback          *trackedNotifier
+	checkedIn         time.Time
+	healthySince      time.Time
+	count             uint
+	lastDelivery      Delivery
+	resolved          bool
+	replicas          map[string]time.Time
+	degraded          bool
+	stateChanges      []time.Time
+	flapping          bool
+	version           uint64
+	decisions         [numAlertKinds]decision
+	pushed            [numAlertKinds]uint64
+	clock             Clock
+	defaulted         bool
+	mu                sync.RWMutex
+	pushMu            sync.Mutex
+}
+
+// applyDefaults sets each field of p that is zero to its default, once
+func (p *Prometheus) applyDefaults() {
+	if p.defaulted {
+		return
+	}
+	p.defaulted = true
+	if p.Expiry == 0 {
+		p.Expiry = 4 * time.Minute
+	}
+	if p.MinReplicas == 0 {
+		p.MinReplicas = 2
+	}
+	if p.ResolveCount == 0 {
+		p.ResolveCount = defaultResolveCount
+	}
+	if p.OnResolved == "" {
+		p.OnResolved = ResolvedAlert
+	}
+	if p.MinDeliveryGap == 0 {
+		p.MinDeliveryGap = 10 * time.Second
+	}
+	if p.FlapHighThreshold == 0 {
+		p.FlapHighThreshold = 4
+	}
+	if p.FlapLowThreshold == 0 {
+		p.FlapLowThreshold = 2
+	}
 }
 
 func (p *Prometheus) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	defaultExpiry, _ := time.ParseDuration("4m")
-	p.Expiry = defaultExpiry
+	p.applyDefaults()
 	type plain Prometheus
-	return unmarshal((*plain)(p))
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+	switch p.OnResolved {
+	case ResolvedAlert, ResolvedMissing, ResolvedIgnore:
+	default:
+		return fmt.Errorf("invalid on_resolved %q, must be one of %q, %q or %q", p.OnResolved, ResolvedAlert, ResolvedMissing, ResolvedIgnore)
+	}
+	if p.ReplicaLabel != "" && p.DegradedAlert.Name == "" {
+		return errors.New("replica_label requires degraded_alert to have a name")
+	}
+	if p.FlapWindow != 0 && p.FlapAlert.Name == "" {
+		return errors.New("flap_window requires flap_alert to have a name")
+	}
+	if p.FlapLowThreshold > p.FlapHighThreshold {
+		return fmt.Errorf("flap_low_threshold %v is higher than flap_high_threshold %v", p.FlapLowThreshold, p.FlapHighThreshold)
+	}
+	return nil
 }
 
-func (p *Prometheus) CheckIn(alert template.Alert) AlertAction {
-	if p.match(alert.Labels) {
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		if alert.Status == "firing" {
-			p.checkedIn = time.Now()
-			p.count += 1
-			// Debounce during state change, wait for 2 alerts before resolving
-			if p.count == 2 {
-				return ActionResolve
+func (p *Prometheus) CheckIn(delivery Delivery, alert template.Alert) AlertAction {
+	if !p.match(alert.Labels) {
+		return ActionNone
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.decide(p.checkIn(delivery, alert))
+}
+
+func (p *Prometheus) checkIn(delivery Delivery, alert template.Alert) AlertAction {
+	if alert.Status == "firing" {
+		p.checkedIn = p.now()
+		// A watchdog without the replica label can't be told apart from
+		// another replica's, so it isn't counted as one
+		if replica, ok := alert.Labels[p.ReplicaLabel]; p.ReplicaLabel != "" && ok {
+			if p.replicas == nil {
+				p.replicas = make(map[string]time.Time)
 			}
-		} else {
+			p.replicas[replica] = p.checkedIn
+		}
+		if p.distinct(delivery) {
+			if p.count == 0 {
+				p.healthySince = p.checkedIn
+			}
+			p.count += 1
+			p.lastDelivery = delivery
+		}
+		// Debounce during state change, wait for ResolveCount alerts,
+		// spanning at least MinHealthy before resolving
+		if !p.resolved && p.count >= p.resolveCount() && p.checkedIn.Sub(p.healthySince) >= p.MinHealthy {
+			p.resolved = true
+			return p.flapped(ActionResolve, true)
+		}
+	} else {
+		switch p.OnResolved {
+		case ResolvedIgnore:
+		case ResolvedMissing:
 			p.count = 0
-			return ActionAlert
+		default:
+			changed := p.resolved
+			p.count = 0
+			p.resolved = false
+			return p.flapped(ActionAlert, changed)
 		}
 	}
 	return ActionNone
 }
 
+// distinct reports if a delivery should be counted towards the debounce.
+// Several matching alerts in one webhook, or the same notification sent
+// by more than one alertmanager replica, are only counted once. Replicas
+// are deliberately not told apart, deliveries of the same group within
+// MinDeliveryGap are duplicates whichever alertmanager sent them.
+func (p *Prometheus) distinct(delivery Delivery) bool {
+	if p.count == 0 {
+		return true
+	}
+	if delivery.ID == p.lastDelivery.ID {
+		return false
+	}
+	// Alertmanager always sends a group key, without one a webhook can't be
+	// recognised as a duplicate
+	if delivery.GroupKey == "" {
+		return true
+	}
+	if delivery.Received.Sub(p.lastDelivery.Received) >= p.MinDeliveryGap {
+		return true
+	}
+	return delivery.GroupKey != p.lastDelivery.GroupKey
+}
+
+func (p *Prometheus) resolveCount() uint {
+	if p.ResolveCount == 0 {
+		return defaultResolveCount
+	}
+	return p.ResolveCount
+}
+
 func (p *Prometheus) Check() AlertAction {
-	if p.Expired() {
-		p.mu.Lock()
-		defer p.mu.Unlock()
+	expired := p.Expired()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.decide(p.check(expired))
+}
+
+func (p *Prometheus) check(expired bool) AlertAction {
+	if expired {
+		changed := p.resolved
 		p.count = 0
-		return ActionAlert
+		p.resolved = false
+		action := p.flapped(ActionAlert, changed)
+		// Once every replica has expired Alert supersedes the degraded alert,
+		// which is resolved at the check after Alert was raised, in place of
+		// repeating Alert, that is still well within its alertExpiry
+		if action == ActionAlert && p.degraded && p.decisions[kindFailure].action == ActionAlert {
+			p.degraded = false
+			return ActionResolveDegraded
+		}
+		return action
+	}
+	if action := p.flapped(ActionNone, false); action != ActionNone {
+		return action
+	}
+	return p.checkReplicas()
+}
+
+// checkReplicas raises the degraded alert while fewer than MinReplicas
+// distinct replicas have checked in within Expiry, and resolves it once
+// enough replicas are reporting again.
+func (p *Prometheus) checkReplicas() AlertAction {
+	if p.ReplicaLabel == "" {
+		return ActionNone
+	}
+	if p.liveReplicas() < p.MinReplicas {
+		p.degraded = true
+		return ActionAlertDegraded
+	}
+	if p.degraded {
+		p.degraded = false
+		return ActionResolveDegraded
 	}
 	return ActionNone
 }
 
+func (p *Prometheus) liveReplicas() int {
+	live := 0
+	now := p.now()
+	for replica, checkedIn := range p.replicas {
+		if now.After(checkedIn.Add(p.Expiry)) {
+			delete(p.replicas, replica)
+			continue
+		}
+		live += 1
+	}
+	return live
+}
+
+// alertFor returns the alert that an action should be applied to
+func (p *Prometheus) alertFor(action AlertAction) alertmanager.Alert {
+	switch action {
+	case ActionAlertDegraded, ActionResolveDegraded:
+		return p.DegradedAlert
+	case ActionAlertFlapping, ActionResolveFlapping:
+		return p.FlapAlert
+	}
+	return p.Alert
+}
+
 func (p *Prometheus) match(labels map[string]string) bool {
 	for key, value := range p.MatchLabels {
 		if labels[key] != value {
@@ -70,8 +279,23 @@ func (p *Prometheus) match(labels map[string]string) bool {
 	return true
 }
 
+// nextCheck returns when the prometheus should next be checked,
+// when it expires or after interval, whichever is sooner.
+func (p *Prometheus) nextCheck(now time.Time, interval time.Duration) time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return nextCheck(now, p.checkedIn.Add(p.Expiry), interval)
+}
+
+func (p *Prometheus) now() time.Time {
+	if p.clock == nil {
+		return time.Now()
+	}
+	return p.clock.Now()
+}
+
 func (p *Prometheus) Expired() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return time.Now().After(p.checkedIn.Add(p.Expiry))
+	return !p.now().Before(p.checkedIn.Add(p.Expiry))
 }
@@ -0,0 +1,58 @@
+package alertdog
+
+// pendingAction is an action waiting to be pushed to alertmanager
+type pendingAction struct {
+	prometheus *Prometheus
+	action     AlertAction
+}
+
+// startWorkers starts the workers that push queued actions
+func (a *Alertdog) startWorkers() {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	a.queue = make(chan pendingAction, a.QueueSize)
+	for i := 0; i < a.Workers; i++ {
+		a.workers.Add(1)
+		go a.work(a.queue)
+	}
+}
+
+// stopWorkers waits for the workers to push any queued actions, then stops them
+func (a *Alertdog) stopWorkers() {
+	a.queueMu.Lock()
+	if a.queue != nil {
+		close(a.queue)
+		a.queue = nil
+	}
+	a.queueMu.Unlock()
+	a.workers.Wait()
+}
+
+func (a *Alertdog) work(queue <-chan pendingAction) {
+	defer a.workers.Done()
+	for pending := range queue {
+		actionQueueDepth.Set(float64(len(queue)))
+		a.act(pending.prometheus, pending.action)
+	}
+}
+
+// enqueue queues an action to be pushed by a worker, so a webhook can be
+// acknowledged without waiting for alertmanager. If the queue is full the
+// action is dropped, alerts are repeated on each check so a dropped
+// alert will be pushed again, and a dropped resolve will expire.
+// Without workers the action is pushed straight away.
+func (a *Alertdog) enqueue(pending pendingAction) {
+	a.queueMu.RLock()
+	defer a.queueMu.RUnlock()
+	if a.queue == nil {
+		a.act(pending.prometheus, pending.action)
+		return
+	}
+	select {
+	case a.queue <- pending:
+		actionQueueDepth.Set(float64(len(a.queue)))
+	default:
+		actionQueueDropped.Inc()
+		a.logger.Printf("Action queue full, dropping action %d for %v", pending.action, pending.prometheus.MatchLabels)
+	}
+}
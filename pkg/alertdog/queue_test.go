@@ -0,0 +1,77 @@
+package alertdog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+// blockingAlertmanager blocks pushes until it is released
+type blockingAlertmanager struct {
+	started chan alertmanager.Alert
+	release chan struct{}
+}
+
+func (b *blockingAlertmanager) Alert(alert alertmanager.Alert) error {
+	b.started <- alert
+	<-b.release
+	return nil
+}
+
+func (b *blockingAlertmanager) Resolve(alert alertmanager.Alert) error {
+	return b.Alert(alert)
+}
+
+func TestWebhookIsAcknowledgedBeforePush(t *testing.T) {
+	blocking := &blockingAlertmanager{
+		started: make(chan alertmanager.Alert, 3),
+		release: make(chan struct{}),
+	}
+	config := Config{
+		QueueSize: 1,
+		Workers:   1,
+	}
+	for i := 1; i <= 3; i++ {
+		config.Expected = append(config.Expected, &Prometheus{
+			MatchLabels: map[string]string{
+				"alertname":  "Watchdog",
+				"prometheus": fmt.Sprintf("prom%d", i),
+			},
+			Alert:  alertmanager.Alert{Name: fmt.Sprintf("prom%d", i)},
+			Expiry: time.Minute,
+		})
+	}
+	alertdog := New(config, WithAlertmanager(blocking), WithNotifiers(newNotifierMock()))
+	alertdog.startWorkers()
+	defer close(blocking.release)
+
+	webhook := func(prometheus string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"alerts": [{"status": "resolved", "labels": {"alertname": "Watchdog", "prometheus": %q}}]}`, prometheus)
+		recorder := httptest.NewRecorder()
+		alertdog.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	}
+
+	dropped := testutil.ToFloat64(actionQueueDropped)
+
+	// The worker is blocked pushing the first alert
+	webhook("prom1")
+	require.Equal(t, "prom1", (<-blocking.started).Name)
+
+	// The second alert is queued
+	webhook("prom2")
+	require.Equal(t, float64(1), testutil.ToFloat64(actionQueueDepth))
+
+	// The queue is full so the third alert is dropped
+	webhook("prom3")
+	require.Equal(t, dropped+1, testutil.ToFloat64(actionQueueDropped))
+}
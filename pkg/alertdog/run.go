@@ -0,0 +1,98 @@
+package alertdog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/errm/alertdog/pkg/web"
+)
+
+// Handler returns the handler that Serve uses, alertdog's own mux, or the
+// one passed to WithMux
+func (a *Alertdog) Handler() http.Handler {
+	return a.mux
+}
+
+// register adds alertdog's endpoints to mux. Only /webhook is added to a mux
+// passed to WithMux, the program embedding alertdog likely has its own
+// /health and /metrics, and can register ServeStatus where it likes.
+func (a *Alertdog) register(mux *http.ServeMux, own bool) {
+	mux.Handle("/webhook", a)
+	if !own {
+		return
+	}
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/status", a.ServeStatus)
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// Run listens on the configured port, and runs alertdog until ctx is cancelled
+func (a *Alertdog) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", a.Port))
+	if err != nil {
+		return err
+	}
+	return a.Serve(ctx, listener)
+}
+
+// Serve handles webhooks on listener, and checks for expired watchdogs
+// until ctx is cancelled.
+//
+// On shutdown in-flight webhooks, and the pushes they cause are completed,
+// and the state is saved. Failed notifications aren't retried once shutdown
+// has started. Nothing is triggered or resolved because of the
+// shutdown itself, so a restart doesn't cause alerts to flap.
+func (a *Alertdog) Serve(ctx context.Context, listener net.Listener) error {
+	if a.escalationErr != nil {
+		return a.escalationErr
+	}
+	if a.WebhookAuth != nil {
+		if err := a.WebhookAuth.checkWebConfig(a.WebConfigFile); err != nil {
+			return err
+		}
+	}
+	if err := a.loadState(); err != nil {
+		a.logger.Printf("Error loading state from %s: %s", a.StateFile, err)
+	}
+	a.startWorkers()
+
+	server := &http.Server{Handler: a.Handler()}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- web.Serve(server, listener, a.WebConfigFile)
+	}()
+
+	checkCtx, stopChecks := context.WithCancel(context.Background())
+	checksDone := make(chan struct{})
+	go func() {
+		a.CheckLoop(checkCtx)
+		close(checksDone)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		a.logger.Println("Shutting down")
+		a.stopNotifying()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			a.logger.Printf("Error waiting for webhooks to complete: %s", err)
+		}
+	case err = <-serveErr:
+	}
+
+	stopChecks()
+	<-checksDone
+	a.stopWorkers()
+	if err := a.saveState(); err != nil {
+		a.logger.Printf("Error saving state to %s: %s", a.StateFile, err)
+	}
+	return err
+}
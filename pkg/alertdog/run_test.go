@@ -0,0 +1,76 @@
+package alertdog
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+func TestServeShutdown(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	newAlertdog := func(recorder *recordingAlertmanager) *Alertdog {
+		return New(Config{
+			Expected: []*Prometheus{
+				&Prometheus{
+					MatchLabels: map[string]string{
+						"alertname":  "Watchdog",
+						"prometheus": "prom1",
+					},
+					Alert:  alertmanager.Alert{Name: "PrometheusAlertFailure"},
+					Expiry: time.Minute,
+				},
+			},
+			CheckInterval:   time.Hour,
+			Expiry:          time.Minute,
+			QueueSize:       10,
+			Workers:         1,
+			ShutdownTimeout: time.Second,
+			StateFile:       stateFile,
+		}, WithAlertmanager(recorder), WithNotifiers(newNotifierMock()))
+	}
+
+	recorder := &recordingAlertmanager{delay: 100 * time.Millisecond}
+	alertdog := newAlertdog(recorder)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- alertdog.Serve(ctx, listener)
+	}()
+
+	webhook := func(status string) {
+		t.Helper()
+		response, err := http.Post("http://"+listener.Addr().String()+"/webhook", "application/json", strings.NewReader(
+			`{"alerts": [{"status": "`+status+`", "labels": {"alertname": "Watchdog", "prometheus": "prom1"}}]}`,
+		))
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	webhook("firing")
+	webhook("firing")
+
+	// The resolve is still being pushed when we shut down
+	cancel()
+	require.NoError(t, <-served)
+
+	recorder.mu.Lock()
+	require.Equal(t, []AlertAction{ActionResolve}, recorder.pushes, "in-flight pushes complete, and nothing else is pushed on shutdown")
+	recorder.mu.Unlock()
+
+	// A restarted alertdog carries on where it left off
+	restarted := newAlertdog(&recordingAlertmanager{})
+	require.NoError(t, restarted.loadState())
+	require.False(t, restarted.Expired())
+	require.True(t, restarted.Expected[0].Status().Healthy)
+}
@@ -0,0 +1,75 @@
+package alertdog
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+// scheduledCheck is a check that runs at a point in time
+type scheduledCheck struct {
+	at time.Time
+	// run performs the check and returns when it should next run
+	run func(now time.Time) time.Time
+}
+
+// checkQueue is a heap of checks ordered by when they are due
+type checkQueue []*scheduledCheck
+
+func (q checkQueue) Len() int            { return len(q) }
+func (q checkQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q checkQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *checkQueue) Push(x interface{}) { *q = append(*q, x.(*scheduledCheck)) }
+func (q *checkQueue) Pop() interface{} {
+	old := *q
+	check := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return check
+}
+
+// scheduler runs each check exactly when it is due, rather than polling
+type scheduler struct {
+	queue checkQueue
+	clock Clock
+}
+
+func (s *scheduler) add(at time.Time, run func(now time.Time) time.Time) {
+	heap.Push(&s.queue, &scheduledCheck{at: at, run: run})
+}
+
+// run blocks running checks as they become due, until stop is closed
+func (s *scheduler) run(stop <-chan struct{}) {
+	if len(s.queue) == 0 {
+		<-stop
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.clock.After(s.queue[0].at.Sub(s.clock.Now())):
+		}
+		now := s.clock.Now()
+		for !s.queue[0].at.After(now) {
+			check := s.queue[0]
+			check.at = check.run(now)
+			heap.Fix(&s.queue, 0)
+		}
+	}
+}
+
+// minCheckInterval stops checks running in a busy loop when the interval, or
+// the jitter applied to it, is too small
+const minCheckInterval = time.Second
+
+// jitter randomly spreads interval by up to +/- the fraction given, it is
+// never less than minCheckInterval
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction > 0 {
+		interval += time.Duration(float64(interval) * fraction * (2*rand.Float64() - 1))
+	}
+	if interval < minCheckInterval {
+		return minCheckInterval
+	}
+	return interval
+}
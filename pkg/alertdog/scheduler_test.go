@@ -0,0 +1,78 @@
+package alertdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestScheduler(t *testing.T) {
+	var (
+		clock = newFakeClock()
+		s     = scheduler{clock: clock}
+		ran   = make(chan string)
+		stop  = make(chan struct{})
+		done  = make(chan struct{})
+		start = clock.Now()
+	)
+
+	record := func(name string, every time.Duration) func(time.Time) time.Time {
+		return func(now time.Time) time.Time {
+			ran <- name
+			return now.Add(every)
+		}
+	}
+
+	s.add(start.Add(30*time.Second), record("slow", time.Hour))
+	s.add(start.Add(10*time.Second), record("fast", 40*time.Second))
+
+	go func() {
+		s.run(stop)
+		close(done)
+	}()
+
+	clock.Advance(10 * time.Second)
+	require.Equal(t, "fast", <-ran)
+	clock.Advance(20 * time.Second)
+	require.Equal(t, "slow", <-ran)
+	clock.Advance(20 * time.Second)
+	require.Equal(t, "fast", <-ran)
+
+	close(stop)
+	<-done
+}
+
+func TestNextCheck(t *testing.T) {
+	now := time.Now()
+	prometheus := &Prometheus{Expiry: 4 * time.Minute}
+
+	prometheus.checkedIn = now.Add(-3 * time.Minute)
+	require.Equal(t, now.Add(time.Minute), prometheus.nextCheck(now, 2*time.Minute), "check exactly when the watchdog expires")
+
+	prometheus.checkedIn = now
+	require.Equal(t, now.Add(2*time.Minute), prometheus.nextCheck(now, 2*time.Minute), "check after the interval if that is sooner")
+
+	prometheus.checkedIn = now.Add(-5 * time.Minute)
+	require.Equal(t, now.Add(2*time.Minute), prometheus.nextCheck(now, 2*time.Minute), "keep checking after the interval once expired")
+}
+
+func TestJitter(t *testing.T) {
+	require.Equal(t, time.Minute, jitter(time.Minute, 0))
+	for i := 0; i < 100; i++ {
+		interval := jitter(time.Minute, 0.1)
+		require.True(t, interval >= 54*time.Second && interval <= 66*time.Second, interval)
+	}
+	require.Equal(t, minCheckInterval, jitter(0, 0))
+	require.Equal(t, minCheckInterval, jitter(time.Millisecond, 0.5))
+}
+
+func TestCheckJitterConfig(t *testing.T) {
+	var config Config
+	require.NoError(t, yaml.Unmarshal([]byte("{}"), &config))
+	require.Equal(t, 0.1, config.CheckJitter)
+	require.NoError(t, yaml.Unmarshal([]byte("check_jitter: 0"), &config))
+	require.Error(t, yaml.Unmarshal([]byte("check_jitter: 1"), &Config{}))
+	require.Error(t, yaml.Unmarshal([]byte("check_jitter: -0.1"), &Config{}))
+}
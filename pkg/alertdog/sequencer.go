@@ -0,0 +1,55 @@
+package alertdog
+
+// alertKind identifies which of a prometheus' alerts an action applies to
+type alertKind int
+
+const (
+	kindFailure alertKind = iota
+	kindDegraded
+	kindFlapping
+	numAlertKinds
+)
+
+func (action AlertAction) kind() alertKind {
+	switch action {
+	case ActionAlertDegraded, ActionResolveDegraded:
+		return kindDegraded
+	case ActionAlertFlapping, ActionResolveFlapping:
+		return kindFlapping
+	}
+	return kindFailure
+}
+
+// decision is an action, versioned in the order it was decided
+type decision struct {
+	action  AlertAction
+	version uint64
+}
+
+// decide records action as the latest decision for the alert it applies to.
+// Must be called with p.mu held, so versions follow the order of decisions.
+func (p *Prometheus) decide(action AlertAction) AlertAction {
+	if action != ActionNone {
+		p.version += 1
+		p.decisions[action.kind()] = decision{action: action, version: p.version}
+	}
+	return action
+}
+
+// sequence pushes the latest decision for the alert that action applies to.
+// Pushes for a prometheus are serialised, and a decision that is older than
+// one already pushed is skipped, so the last decided state always wins even
+// when a webhook and a check race each other.
+func (p *Prometheus) sequence(action AlertAction, push func(AlertAction) error) error {
+	kind := action.kind()
+	p.pushMu.Lock()
+	defer p.pushMu.Unlock()
+	p.mu.RLock()
+	latest := p.decisions[kind]
+	p.mu.RUnlock()
+	if latest.version <= p.pushed[kind] {
+		return nil
+	}
+	p.pushed[kind] = latest.version
+	return push(latest.action)
+}
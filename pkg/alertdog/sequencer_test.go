@@ -0,0 +1,105 @@
+package alertdog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+// recordingAlertmanager records the actions pushed to it
+type recordingAlertmanager struct {
+	mu     sync.Mutex
+	pushes []AlertAction
+	delay  time.Duration
+}
+
+func (r *recordingAlertmanager) Alert(alertmanager.Alert) error {
+	return r.record(ActionAlert)
+}
+
+func (r *recordingAlertmanager) Resolve(alertmanager.Alert) error {
+	return r.record(ActionResolve)
+}
+
+func (r *recordingAlertmanager) record(action AlertAction) error {
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushes = append(r.pushes, action)
+	return nil
+}
+
+func TestSequenceSkipsStaleDecisions(t *testing.T) {
+	prometheus := &Prometheus{}
+	var pushed []AlertAction
+	push := func(action AlertAction) error {
+		pushed = append(pushed, action)
+		return nil
+	}
+
+	alert := prometheus.decide(ActionAlert)
+	resolve := prometheus.decide(ActionResolve)
+
+	// The alert push happens after the resolve was decided, so the resolve wins
+	require.NoError(t, prometheus.sequence(alert, push))
+	require.NoError(t, prometheus.sequence(resolve, push))
+	require.Equal(t, []AlertAction{ActionResolve}, pushed)
+
+	// Decisions for other alerts are sequenced separately
+	degraded := prometheus.decide(ActionAlertDegraded)
+	require.NoError(t, prometheus.sequence(degraded, push))
+	require.Equal(t, []AlertAction{ActionResolve, ActionAlertDegraded}, pushed)
+}
+
+func TestSequenceRace(t *testing.T) {
+	recorder := &recordingAlertmanager{delay: time.Millisecond}
+	prometheus := &Prometheus{
+		MatchLabels: map[string]string{
+			"alertname":  "Watchdog",
+			"prometheus": "prom1",
+		},
+		Expiry: time.Minute,
+	}
+	alertdog := New(Config{
+		Expected: []*Prometheus{prometheus},
+		Expiry:   time.Minute,
+	}, WithAlertmanager(recorder), WithNotifiers(newNotifierMock()))
+
+	watchdog := func(status string) template.Alert {
+		return template.Alert{
+			Status: status,
+			Labels: template.KV{
+				"alertname":  "Watchdog",
+				"prometheus": "prom1",
+			},
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("resolved"))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				alertdog.processWatchdog(alertdog.newDelivery(""), watchdog("firing"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	require.NotEmpty(t, recorder.pushes)
+	require.Equal(t, prometheus.decisions[kindFailure].action, recorder.pushes[len(recorder.pushes)-1], "the last decided state is pushed last")
+}
@@ -0,0 +1,114 @@
+package alertdog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+// state is saved on shutdown, so a restarted alertdog carries on where it
+// left off, rather than re-alerting or re-resolving
+type state struct {
+	LastWebhook time.Time         `json:"last_webhook"`
+	Expected    []prometheusState `json:"expected"`
+	// Where firing alerts were delivered, so they are resolved there
+	Deliveries []DeliveryStatus `json:"deliveries,omitempty"`
+	// The keys of the incidents open with each notifier, by name
+	Notifiers map[string][]string `json:"notifiers,omitempty"`
+}
+
+type prometheusState struct {
+	MatchLabels map[string]string `json:"match_labels"`
+	CheckedIn   time.Time         `json:"checked_in"`
+	Resolved    bool              `json:"resolved"`
+	// The keys of alerts delivered by the fallback notifier that are still open
+	Fallback []string `json:"fallback,omitempty"`
+}
+
+func (a *Alertdog) saveState() error {
+	if a.StateFile == "" {
+		return nil
+	}
+	a.mu.RLock()
+	s := state{LastWebhook: a.checkedIn}
+	a.mu.RUnlock()
+	for _, prometheus := range a.Expected {
+		prometheus.mu.RLock()
+		saved := prometheusState{
+			MatchLabels: prometheus.MatchLabels,
+			CheckedIn:   prometheus.checkedIn,
+			Resolved:    prometheus.resolved,
+		}
+		prometheus.mu.RUnlock()
+		if prometheus.fallback != nil {
+			saved.Fallback = prometheus.fallback.triggered()
+		}
+		s.Expected = append(s.Expected, saved)
+	}
+	if a.failover != nil {
+		s.Deliveries = a.failover.status()
+	}
+	for _, notifier := range a.notifiers {
+		if open := notifier.triggered(); len(open) > 0 {
+			if s.Notifiers == nil {
+				s.Notifiers = map[string][]string{}
+			}
+			s.Notifiers[notifier.name] = open
+		}
+	}
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write then rename, so an interrupted save doesn't leave a partial file
+	tmp := a.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.StateFile)
+}
+
+func (a *Alertdog) loadState() error {
+	if a.StateFile == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(a.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s state
+	if err := json.Unmarshal(content, &s); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.checkedIn = s.LastWebhook
+	a.mu.Unlock()
+	// Expected prometheus are matched up by their labels, in case the config
+	// has been reordered or changed since the state was saved
+	for _, saved := range s.Expected {
+		for _, prometheus := range a.Expected {
+			if !reflect.DeepEqual(saved.MatchLabels, prometheus.MatchLabels) {
+				continue
+			}
+			prometheus.mu.Lock()
+			prometheus.checkedIn = saved.CheckedIn
+			prometheus.resolved = saved.Resolved
+			prometheus.mu.Unlock()
+			if prometheus.fallback != nil {
+				prometheus.fallback.restore(saved.Fallback)
+			}
+		}
+	}
+	if a.failover != nil {
+		a.failover.restore(s.Deliveries)
+	}
+	for _, notifier := range a.notifiers {
+		notifier.restore(s.Notifiers[notifier.name])
+	}
+	return nil
+}
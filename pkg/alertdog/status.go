@@ -0,0 +1,70 @@
+package alertdog
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type Status struct {
+	LastWebhook time.Time          `json:"last_webhook"`
+	Expected    []PrometheusStatus `json:"expected"`
+	// Open incidents, when there is an escalation policy
+	Escalations []EscalationStatus `json:"escalations,omitempty"`
+	// Where firing alerts were delivered, when there is a secondary alertmanager
+	Deliveries []DeliveryStatus `json:"deliveries,omitempty"`
+}
+
+type PrometheusStatus struct {
+	MatchLabels map[string]string `json:"match_labels"`
+	LastCheckIn time.Time         `json:"last_check_in"`
+	Healthy     bool              `json:"healthy"`
+	Replicas    int               `json:"replicas,omitempty"`
+	Degraded    bool              `json:"degraded,omitempty"`
+	FlapScore   float64           `json:"flap_score"`
+	Flapping    bool              `json:"flapping"`
+}
+
+func (a *Alertdog) Status() Status {
+	a.mu.RLock()
+	status := Status{LastWebhook: a.checkedIn}
+	a.mu.RUnlock()
+	for _, prometheus := range a.Expected {
+		status.Expected = append(status.Expected, prometheus.Status())
+	}
+	if a.escalation != nil {
+		status.Escalations = a.escalation.status()
+	}
+	if a.failover != nil {
+		status.Deliveries = a.failover.status()
+	}
+	return status
+}
+
+func (p *Prometheus) Status() PrometheusStatus {
+	expired := p.Expired()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := PrometheusStatus{
+		MatchLabels: p.MatchLabels,
+		LastCheckIn: p.checkedIn,
+		Healthy:     p.resolved && !expired,
+		Degraded:    p.degraded,
+		Flapping:    p.flapping,
+	}
+	if p.ReplicaLabel != "" {
+		status.Replicas = p.liveReplicas()
+	}
+	if p.FlapWindow > 0 {
+		status.FlapScore = p.flapScore(p.now())
+	}
+	return status
+}
+
+// ServeStatus responds with the current Status as JSON
+func (a *Alertdog) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.Status()); err != nil {
+		a.logger.Printf("Error writing status: %s", err)
+	}
+}
@@ -0,0 +1,14 @@
+package alertdogtest
+
+import (
+	"time"
+
+	"github.com/errm/alertdog/pkg/alertdogtest/fakeclock"
+)
+
+// FakeClock is an alertdog.Clock that only moves forward when it is advanced
+type FakeClock = fakeclock.Clock
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return fakeclock.New(now)
+}
@@ -0,0 +1,158 @@
+// Package alertdogtest provides fakes of the services alertdog talks to,
+// and a harness that runs a complete alertdog against them, for use in
+// integration tests.
+package alertdogtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// faults are injected into the responses of a fake server
+type faults struct {
+	mu         sync.Mutex
+	status     int
+	next       []int
+	retryAfter string
+	latency    time.Duration
+	requests   int
+}
+
+// SetStatus makes the server respond with status, rather than success
+// Set it to 0 to succeed again.
+func (f *faults) SetStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// FailNext makes the server respond to the next requests with each of
+// statuses in turn, before responding as it did before. A status of 0
+// succeeds.
+func (f *faults) FailNext(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next = append(f.next, statuses...)
+}
+
+// SetRetryAfter sets the Retry-After header of the responses with an
+// injected status, e.g. "1" to ask for a retry after a second
+func (f *faults) SetRetryAfter(retryAfter string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.retryAfter = retryAfter
+}
+
+// Requests returns how many requests the server has had, including those
+// that failed
+func (f *faults) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// SetLatency makes the server wait before responding
+func (f *faults) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+}
+
+// inject waits for any latency, and writes the injected status.
+// It returns false if a status was written.
+func (f *faults) inject(w http.ResponseWriter) bool {
+	f.mu.Lock()
+	f.requests++
+	status, retryAfter, latency := f.status, f.retryAfter, f.latency
+	if len(f.next) > 0 {
+		status, f.next = f.next[0], f.next[1:]
+	}
+	f.mu.Unlock()
+	time.Sleep(latency)
+	if status != 0 {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "error"})
+		return false
+	}
+	return true
+}
+
+// Alert is an alert as pushed to alertmanager
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	// API is the alertmanager API version the alert was pushed with, v1 or v2
+	API string `json:"-"`
+}
+
+// Resolved reports if the alert was pushed as resolved
+func (a Alert) Resolved() bool {
+	return !a.EndsAt.After(a.StartsAt)
+}
+
+// Alertmanager is an in memory alertmanager that records the alerts pushed
+// to its v1 and v2 alerts APIs
+type Alertmanager struct {
+	faults
+	*httptest.Server
+
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func NewAlertmanager() *Alertmanager {
+	a := &Alertmanager{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/alerts", a.handler("v1"))
+	mux.HandleFunc("/api/v2/alerts", a.handler("v2"))
+	a.Server = httptest.NewServer(mux)
+	return a
+}
+
+func (a *Alertmanager) handler(api string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !a.inject(w) {
+			return
+		}
+		var alerts []Alert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.mu.Lock()
+		for _, alert := range alerts {
+			alert.API = api
+			a.alerts = append(a.alerts, alert)
+		}
+		a.mu.Unlock()
+		if api == "v1" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		}
+	}
+}
+
+// Alerts returns the alerts that have been pushed, oldest first
+func (a *Alertmanager) Alerts() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Alert(nil), a.alerts...)
+}
+
+// Reset forgets the alerts that have been pushed
+func (a *Alertmanager) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alerts = nil
+}
@@ -0,0 +1,112 @@
+package alertdogtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+
+	"github.com/errm/alertdog/pkg/alertdog"
+)
+
+// Harness runs a complete alertdog that pushes alerts to a fake
+// Alertmanager and sends events to a fake PagerDuty, using a fake clock.
+type Harness struct {
+	Alertmanager *Alertmanager
+	PagerDuty    *PagerDuty
+	Clock        *FakeClock
+	Alertdog     *alertdog.Alertdog
+	// The URL alertdog is serving on
+	URL string
+
+	cancel   context.CancelFunc
+	done     chan error
+	stopOnce sync.Once
+}
+
+// NewHarness starts an alertdog for config, it is stopped when the test
+// completes. The alertmanager endpoints and notifiers in config are
+// replaced by the fakes, options can be used to further customise alertdog.
+func NewHarness(t testing.TB, config alertdog.Config, options ...alertdog.Option) *Harness {
+	t.Helper()
+	h := &Harness{
+		Alertmanager: NewAlertmanager(),
+		PagerDuty:    NewPagerDuty(),
+		Clock:        NewFakeClock(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)),
+		done:         make(chan error, 1),
+	}
+	config.AlertmanagerEndpoints = []string{h.Alertmanager.URL}
+	pagerDuty := h.PagerDuty.Config()
+	config.Notifiers = []alertdog.NotifierConfig{{PagerDuty: &pagerDuty}}
+	options = append([]alertdog.Option{
+		alertdog.WithClock(h.Clock),
+		alertdog.WithLogger(log.New(ioutil.Discard, "", 0)),
+	}, options...)
+	h.Alertdog = alertdog.New(config, options...)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.URL = "http://" + listener.Addr().String()
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	go func() {
+		h.done <- h.Alertdog.Serve(ctx, listener)
+	}()
+	// Wait for checks to be scheduled, so advancing the clock triggers them
+	h.Clock.BlockUntil(1)
+	t.Cleanup(func() {
+		if err := h.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	return h
+}
+
+// Stop shuts alertdog down, and closes the fake servers
+func (h *Harness) Stop() error {
+	var err error
+	h.stopOnce.Do(func() {
+		h.cancel()
+		err = <-h.done
+		h.Alertmanager.Close()
+		h.PagerDuty.Close()
+	})
+	return err
+}
+
+// Webhook sends alerts to alertdog's webhook, as alertmanager would
+func (h *Harness) Webhook(alerts ...template.Alert) error {
+	body, err := json.Marshal(template.Data{Alerts: alerts})
+	if err != nil {
+		return err
+	}
+	response, err := http.Post(h.URL+"/webhook", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// Watchdog returns a watchdog alert with labels
+func Watchdog(status string, labels map[string]string) template.Alert {
+	kv := template.KV{"alertname": "Watchdog"}
+	for name, value := range labels {
+		kv[name] = value
+	}
+	return template.Alert{Status: status, Labels: kv}
+}
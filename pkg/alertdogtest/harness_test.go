@@ -0,0 +1,78 @@
+package alertdogtest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/errm/alertdog/pkg/alertdog"
+	"github.com/errm/alertdog/pkg/alertmanager"
+)
+
+func TestHarness(t *testing.T) {
+	h := NewHarness(t, alertdog.Config{
+		Expected: []*alertdog.Prometheus{
+			&alertdog.Prometheus{
+				MatchLabels: map[string]string{
+					"alertname":  "Watchdog",
+					"prometheus": "prom1",
+				},
+				Alert:  alertmanager.Alert{Name: "PrometheusAlertFailure"},
+				Expiry: 4 * time.Minute,
+			},
+		},
+		CheckInterval: 2 * time.Minute,
+		Expiry:        5 * time.Minute,
+		QueueSize:     10,
+		Workers:       1,
+	})
+
+	watchdog := Watchdog("firing", map[string]string{"prometheus": "prom1"})
+	require.NoError(t, h.Webhook(watchdog))
+	require.NoError(t, h.Webhook(watchdog))
+
+	// The failure alert is resolved after 2 watchdogs
+	require.Eventually(t, func() bool { return len(h.Alertmanager.Alerts()) == 1 }, time.Second, time.Millisecond)
+	alert := h.Alertmanager.Alerts()[0]
+	require.Equal(t, "PrometheusAlertFailure", alert.Labels["alertname"])
+	require.Equal(t, "v1", alert.API)
+	require.True(t, alert.Resolved())
+
+	// The failure alert fires once the watchdog expires
+	h.Clock.Advance(4 * time.Minute)
+	require.Eventually(t, func() bool { return len(h.Alertmanager.Alerts()) == 2 }, time.Second, time.Millisecond)
+	require.False(t, h.Alertmanager.Alerts()[1].Resolved())
+
+	// PagerDuty is used if alertmanager is broken
+	h.Alertmanager.SetStatus(http.StatusInternalServerError)
+	// check_interval, plus the most that check_jitter can add to it
+	h.Clock.Advance(2*time.Minute + 12*time.Second)
+	require.Eventually(t, func() bool {
+		for _, event := range h.PagerDuty.Events() {
+			if event.DedupKey == "alertdog:alertmanager-push" && event.Action == "trigger" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
+
+func TestAlertmanagerV2(t *testing.T) {
+	am := NewAlertmanager()
+	defer am.Close()
+
+	response, err := http.Post(am.URL+"/api/v2/alerts", "application/json", strings.NewReader(`[{"labels": {"alertname": "Test"}}]`))
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "v2", am.Alerts()[0].API)
+
+	am.SetStatus(http.StatusServiceUnavailable)
+	response, err = http.Post(am.URL+"/api/v2/alerts", "application/json", strings.NewReader(`[]`))
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+}
@@ -0,0 +1,74 @@
+package alertdogtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/PagerDuty/go-pagerduty"
+
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+// PagerDuty is a fake PagerDuty Events API v2 endpoint, that records the
+// events sent to it
+type PagerDuty struct {
+	faults
+	*httptest.Server
+
+	mu     sync.Mutex
+	events []pagerduty.V2Event
+}
+
+func NewPagerDuty() *PagerDuty {
+	p := &PagerDuty{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/enqueue", p.enqueue)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *PagerDuty) enqueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.inject(w) {
+		return
+	}
+	var event pagerduty.V2Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	p.events = append(p.events, event)
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(pagerduty.V2EventResponse{
+		Status:   "success",
+		DedupKey: event.DedupKey,
+		Message:  "Event processed",
+	})
+}
+
+// Events returns the events that have been sent, oldest first
+func (p *PagerDuty) Events() []pagerduty.V2Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]pagerduty.V2Event(nil), p.events...)
+}
+
+// Reset forgets the events that have been sent
+func (p *PagerDuty) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = nil
+}
+
+// Config returns the configuration of a PagerDuty notifier that sends events
+// to the fake
+func (p *PagerDuty) Config() notify.PagerDutyConfig {
+	return notify.PagerDutyConfig{URL: p.URL + "/v2/enqueue"}
+}
@@ -2,7 +2,6 @@ package alertmanager
 
 import (
 	"context"
-	"errors"
 	"log"
 	"sync"
 	"time"
@@ -15,6 +14,8 @@ import (
 type Alertmanager struct {
 	Endpoints []string
 	Expiry    time.Duration
+	// Logf logs the errors from each endpoint (defaults to log.Printf)
+	Logf func(format string, v ...interface{})
 }
 
 func (a Alertmanager) Alert(alert Alert) error {
@@ -33,14 +34,30 @@ func (a Alertmanager) Resolve(alert Alert) error {
 	return a.push(clientAlert)
 }
 
+// PushError is returned when an alert could not be pushed to any alertmanager
+type PushError struct {
+	// The error from each endpoint
+	Errors map[string]error
+}
+
+func (e *PushError) Error() string {
+	return "Failed to push alert to any alertmanager"
+}
+
 // push sends the alerts to all configured Alertmanagers concurrently
-// It returns an error if the alerts could not be sent successfully to at least one Alertmanager.
+// It returns a *PushError if the alerts could not be sent successfully to at least one Alertmanager.
 // Somewhat based upon https://github.com/prometheus/prometheus/blob/main/notifier/notifier.go
 func (a Alertmanager) push(alert client.Alert) error {
 	var (
 		pushes atomic.Int64
 		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = map[string]error{}
+		logf   = a.Logf
 	)
+	if logf == nil {
+		logf = log.Printf
+	}
 
 	for _, endpoint := range a.Endpoints {
 		wg.Add(1)
@@ -51,13 +68,19 @@ func (a Alertmanager) push(alert client.Alert) error {
 			defer wg.Done()
 			apiClient, err := api.NewClient(api.Config{Address: address})
 			if err != nil {
-				log.Printf("Error configuring apiclient for %s - %s", address, err)
+				logf("Error configuring apiclient for %s - %s", address, err)
+				mu.Lock()
+				errs[address] = err
+				mu.Unlock()
 				return
 			}
 			alertClient := client.NewAlertAPI(apiClient)
 			err = alertClient.Push(ctx, alert)
 			if err != nil {
-				log.Printf("Error pushing alert to %s - %s", address, err)
+				logf("Error pushing alert to %s - %s", address, err)
+				mu.Lock()
+				errs[address] = err
+				mu.Unlock()
 				return
 			}
 			pushes.Inc()
@@ -67,7 +90,7 @@ func (a Alertmanager) push(alert client.Alert) error {
 	wg.Wait()
 
 	if pushes.Load() < 1 {
-		return errors.New("Failed to push alert to any alertmanager")
+		return &PushError{Errors: errs}
 	}
 
 	return nil
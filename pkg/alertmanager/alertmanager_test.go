@@ -2,6 +2,7 @@ package alertmanager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -112,12 +113,16 @@ func TestAlert(t *testing.T) {
 
 	// Both servers error
 	status1.Store(int32(http.StatusNotFound))
-	require.Error(t, alertManager.Alert(Alert{
+	err := alertManager.Alert(Alert{
 		Name: "PrometheusAlertFailure",
 		Labels: map[string]string{
 			"foo": "bar",
 		},
-	}), "Alerting succeeded unexpectedly")
+	})
+	require.Error(t, err, "Alerting succeeded unexpectedly")
+	var pushError *PushError
+	require.True(t, errors.As(err, &pushError))
+	require.Len(t, pushError.Errors, 2)
 	checkNoErr()
 
 	//Timeout
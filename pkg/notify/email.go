@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmailSubject = `[{{ .Status | upper }}] {{ .Summary }}`
+	defaultEmailBody    = `{{ .Summary }}
+
+Status: {{ .Status }}
+Severity: {{ .Severity }}
+Incident: {{ .Key }}
+{{ range $key, $value := .Details }}{{ $key }}: {{ $value }}
+{{ end }}{{ range .Links }}
+{{ .Text }}: {{ .Href }}{{ end }}
+`
+)
+
+// EmailConfig configures how incidents are emailed with SMTP
+type EmailConfig struct {
+	// The SMTP server, as host:port
+	Smarthost string
+	From      string
+	To        []string
+	// The hostname sent in the SMTP HELO (defaults to localhost)
+	Hello            string
+	AuthUsername     string `yaml:"auth_username"`
+	AuthPasswordFile string `yaml:"auth_password_file"`
+	AuthIdentity     string `yaml:"auth_identity"`
+	// Fail if the server doesn't support STARTTLS
+	RequireTLS bool      `yaml:"require_tls"`
+	TLSConfig  TLSConfig `yaml:"tls_config"`
+	// How long to wait for the whole email to be sent (defaults to 10s)
+	Timeout time.Duration
+	// text/template templates executed with TemplateData
+	Subject string
+	Body    string
+}
+
+func (c *EmailConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.RequireTLS = true
+	type plain EmailConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Smarthost == "" || c.From == "" || len(c.To) == 0 {
+		return errors.New("email requires smarthost, from and to")
+	}
+	if _, err := parseTemplate("subject", c.Subject); err != nil {
+		return err
+	}
+	_, err := parseTemplate("body", c.Body)
+	return err
+}
+
+// Email sends incidents as plain text emails
+type Email struct {
+	EmailConfig
+}
+
+func NewEmail(config EmailConfig) *Email {
+	return &Email{EmailConfig: config}
+}
+
+func (e *Email) Trigger(ctx context.Context, incident Incident) error {
+	return e.send(incident, StatusFiring)
+}
+
+func (e *Email) Resolve(ctx context.Context, incident Incident) error {
+	return e.send(incident, StatusResolved)
+}
+
+func (e *Email) send(incident Incident, status string) error {
+	subjectTemplate, bodyTemplate := e.Subject, e.Body
+	if subjectTemplate == "" {
+		subjectTemplate = defaultEmailSubject
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = defaultEmailBody
+	}
+	subject, err := executeTemplate("subject", subjectTemplate, incident, status)
+	if err != nil {
+		return err
+	}
+	body, err := executeTemplate("body", bodyTemplate, incident, status)
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", e.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&message, "\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return e.deliver(message.Bytes())
+}
+
+// deliver sends message to the smarthost, within Timeout
+func (e *Email) deliver(message []byte) error {
+	host, _, err := net.SplitHostPort(e.Smarthost)
+	if err != nil {
+		return err
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial("tcp", e.Smarthost)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	hello := e.Hello
+	if hello == "" {
+		hello = "localhost"
+	}
+	if err := client.Hello(hello); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig, err := e.tlsConfig(host)
+		if err != nil {
+			return err
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	} else if e.RequireTLS {
+		return fmt.Errorf("%s does not support STARTTLS", e.Smarthost)
+	}
+	if e.AuthUsername != "" {
+		password, err := ioutil.ReadFile(e.AuthPasswordFile)
+		if err != nil {
+			return err
+		}
+		auth := smtp.PlainAuth(e.AuthIdentity, e.AuthUsername, strings.TrimSpace(string(password)), host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (e *Email) tlsConfig(host string) (*tls.Config, error) {
+	config, err := e.TLSConfig.newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = host
+	return config, nil
+}
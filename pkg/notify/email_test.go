@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+// smtpServer is an in-process SMTP server, that records the emails sent to it
+type smtpServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	mu     sync.Mutex
+	emails []smtpEmail
+}
+
+type smtpEmail struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string
+}
+
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpServer{listener: listener, tlsConfig: tlsConfig}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *smtpServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpServer) Emails() []smtpEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpEmail(nil), s.emails...)
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	var email smtpEmail
+	_ = text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			extensions := []string{"250-localhost", "250-AUTH PLAIN"}
+			if s.tlsConfig != nil && !email.tls {
+				extensions = append(extensions, "250-STARTTLS")
+			}
+			for _, extension := range extensions {
+				_ = text.PrintfLine("%s", extension)
+			}
+			_ = text.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			_ = text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			email.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			email.auth = string(credentials)
+			_ = text.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			email.from = strings.SplitN(strings.TrimPrefix(line, "MAIL FROM:<"), ">", 2)[0]
+			_ = text.PrintfLine("250 OK")
+		case "RCPT":
+			email.to = append(email.to, strings.SplitN(strings.TrimPrefix(line, "RCPT TO:<"), ">", 2)[0])
+			_ = text.PrintfLine("250 OK")
+		case "DATA":
+			_ = text.PrintfLine("354 Go ahead")
+			// line endings are converted to \n
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			email.data = string(data)
+			s.mu.Lock()
+			s.emails = append(s.emails, email)
+			s.mu.Unlock()
+			_ = text.PrintfLine("250 OK")
+		case "QUIT":
+			_ = text.PrintfLine("221 Bye")
+			return
+		default:
+			_ = text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// newSMTPCertificate returns a self signed certificate for 127.0.0.1, and a
+// file containing it to be used as a CA
+func newSMTPCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+var emailIncident = Incident{
+	Key:      "alertdog:webhook-expiry",
+	Summary:  "Alertdog: didn't receive webhook from alert manager for over 5m0s",
+	Severity: SeverityCritical,
+	Details:  map[string]string{"last_webhook": "2021-03-01T00:00:00Z"},
+	Links:    []Link{{Text: "Runbook", Href: "https://example.org/runbook"}},
+}
+
+func TestEmail(t *testing.T) {
+	cert, caFile := newSMTPCertificate(t)
+	server := newSMTPServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("hunter2\n"), 0600))
+
+	email := NewEmail(EmailConfig{
+		Smarthost:        server.Addr(),
+		From:             "alertdog@example.org",
+		To:               []string{"sre@example.org", "oncall@example.org"},
+		AuthUsername:     "alertdog",
+		AuthPasswordFile: passwordFile,
+		RequireTLS:       true,
+		TLSConfig:        TLSConfig{CAFile: caFile},
+	})
+	require.NoError(t, email.Trigger(context.Background(), emailIncident))
+	require.NoError(t, email.Resolve(context.Background(), emailIncident))
+
+	emails := server.Emails()
+	require.Len(t, emails, 2)
+	trigger := emails[0]
+	require.True(t, trigger.tls)
+	require.Equal(t, "\x00alertdog\x00hunter2", trigger.auth)
+	require.Equal(t, "alertdog@example.org", trigger.from)
+	require.Equal(t, []string{"sre@example.org", "oncall@example.org"}, trigger.to)
+	require.Contains(t, trigger.data, "Subject: [FIRING] Alertdog: didn't receive webhook from alert manager for over 5m0s\n")
+	require.Contains(t, trigger.data, "To: sre@example.org, oncall@example.org\n")
+	require.Contains(t, trigger.data, "Incident: alertdog:webhook-expiry\n")
+	require.Contains(t, trigger.data, "last_webhook: 2021-03-01T00:00:00Z\n")
+	require.Contains(t, trigger.data, "Runbook: https://example.org/runbook\n")
+
+	require.Contains(t, emails[1].data, "Subject: [RESOLVED] Alertdog")
+}
+
+func TestEmailTemplates(t *testing.T) {
+	server := newSMTPServer(t, nil)
+	email := NewEmail(EmailConfig{
+		Smarthost: server.Addr(),
+		From:      "alertdog@example.org",
+		To:        []string{"sre@example.org"},
+		Subject:   `{{ if eq .Status "resolved" }}✅{{ else }}🚨{{ end }} {{ .Key }}`,
+		Body:      `{{ .Summary }} is {{ .Status }}`,
+	})
+	require.NoError(t, email.Trigger(context.Background(), emailIncident))
+
+	emails := server.Emails()
+	require.Len(t, emails, 1)
+	require.False(t, emails[0].tls)
+	require.Contains(t, emails[0].data, "Subject: =?utf-8?q?=F0=9F=9A=A8_alertdog:webhook-expiry?=\n")
+	require.True(t, strings.HasSuffix(emails[0].data, "\n\nAlertdog: didn't receive webhook from alert manager for over 5m0s is firing\n"))
+}
+
+func TestEmailRequireTLS(t *testing.T) {
+	server := newSMTPServer(t, nil)
+	email := NewEmail(EmailConfig{
+		Smarthost:  server.Addr(),
+		From:       "alertdog@example.org",
+		To:         []string{"sre@example.org"},
+		RequireTLS: true,
+	})
+	require.Error(t, email.Trigger(context.Background(), emailIncident))
+	require.Empty(t, server.Emails())
+}
+
+func TestEmailConfig(t *testing.T) {
+	var config EmailConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+smarthost: smtp.example.org:587
+from: alertdog@example.org
+to: [sre@example.org]
+`), &config))
+	require.True(t, config.RequireTLS)
+
+	require.Error(t, yaml.Unmarshal([]byte(`smarthost: smtp.example.org:587`), &EmailConfig{}))
+	require.Error(t, yaml.Unmarshal([]byte(`
+smarthost: smtp.example.org:587
+from: alertdog@example.org
+to: [sre@example.org]
+subject: "{{ .Summary"
+`), &EmailConfig{}))
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPClientConfig configures the HTTP client used to talk to an API
+type HTTPClientConfig struct {
+	// How long to wait for each request (defaults to 10s)
+	Timeout   time.Duration
+	ProxyURL  string    `yaml:"proxy_url"`
+	TLSConfig TLSConfig `yaml:"tls_config"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (c TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		caCerts, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c HTTPClientConfig) newClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig.newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// lazyClient is an http.Client that is created the first time it is used
+type lazyClient struct {
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+func (l *lazyClient) get(config HTTPClientConfig) (*http.Client, error) {
+	l.once.Do(func() {
+		l.client, l.err = config.newClient()
+	})
+	return l.client, l.err
+}
+
+// RetryConfig configures how failed requests are retried
+type RetryConfig struct {
+	// How many times a request is retried (defaults to 3 when read from a
+	// config file)
+	MaxRetries int `yaml:"max_retries"`
+	// How long to wait before the first retry, doubling for each retry after that (defaults to 1s)
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+// retry calls attempt until it succeeds, the error isn't retryable,
+// MaxRetries is reached, or ctx is done. ctx doesn't interrupt an attempt,
+// only the wait before the next one. A Retry-After longer than the backoff
+// is waited for, up to the backoff of the last retry.
+func (c RetryConfig) retry(ctx context.Context, attempt func() error) error {
+	backoff := c.RetryBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	maxWait := backoff << uint(c.MaxRetries)
+	for retries := 0; ; retries++ {
+		err := attempt()
+		retryable, ok := err.(retryableError)
+		if !ok || retries >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait := backoff
+		if retryable.after > wait {
+			wait = retryable.after
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// retryableError is an error from a request that might succeed if it is
+// retried, after is how long the server asked us to wait
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// statusError returns the error for a response with an unexpected status
+func statusError(response *http.Response) error {
+	message, _ := ioutil.ReadAll(response.Body)
+	err := fmt.Errorf("HTTP Status Code: %d, Message: %s", response.StatusCode, message)
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode < http.StatusInternalServerError {
+		return err
+	}
+	after, _ := strconv.Atoi(response.Header.Get("Retry-After"))
+	return retryableError{err: err, after: time.Duration(after) * time.Second}
+}
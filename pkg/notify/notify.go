@@ -0,0 +1,71 @@
+// Package notify sends alertdog's own incidents, such as not being able to
+// push alerts to alertmanager, to paging and chat tools.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// Severities an incident can have, as used by the PagerDuty Events API
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Types of incident raised by alertdog
+const (
+	TypeWebhookExpiry    = "webhook-expiry"
+	TypeAlertmanagerPush = "alertmanager-push"
+)
+
+// Incident is something that is wrong with alerting, that a human needs to know about
+type Incident struct {
+	// Key identifies the incident, a resolve has the same key as its trigger
+	Key string `json:"key"`
+	// Type is what kind of incident it is, e.g. webhook-expiry
+	Type     string            `json:"type,omitempty"`
+	Summary  string            `json:"summary"`
+	Severity string            `json:"severity"`
+	Details  map[string]string `json:"details,omitempty"`
+	Links    []Link            `json:"links,omitempty"`
+	Images   []Image           `json:"images,omitempty"`
+	Context  Context           `json:"context"`
+}
+
+// Context is what alertdog knew about alerting when an incident was raised
+type Context struct {
+	AlertmanagerEndpoints []string `json:"alertmanager_endpoints,omitempty"`
+	// The alertmanager endpoints that alerts couldn't be pushed to
+	FailingEndpoints []string  `json:"failing_endpoints,omitempty"`
+	LastWebhook      time.Time `json:"last_webhook"`
+	// The match labels of the expected prometheus that are affected
+	AffectedTargets []map[string]string `json:"affected_targets,omitempty"`
+}
+
+type Link struct {
+	Text string `json:"text"`
+	Href string `json:"href"`
+}
+
+type Image struct {
+	Src  string `json:"src"`
+	Href string `json:"href,omitempty"`
+	Alt  string `json:"alt,omitempty"`
+}
+
+// Notifier raises incidents. Failed requests may be retried until ctx is
+// done, each request is bounded by the notifier's timeout rather than ctx,
+// so one that has started is completed.
+type Notifier interface {
+	Trigger(ctx context.Context, incident Incident) error
+	Resolve(ctx context.Context, incident Incident) error
+}
+
+// Acknowledger is implemented by notifiers that can tell if a human has
+// acknowledged an incident
+type Acknowledger interface {
+	Acknowledged(ctx context.Context, incident Incident) (bool, error)
+}
@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultOpsgenieAPIURL = "https://api.opsgenie.com"
+
+// OpsgenieConfig configures how alerts are created with the Opsgenie Alerts API
+type OpsgenieConfig struct {
+	// A file containing an API key of an API integration
+	APIKeyFile string `yaml:"api_key_file"`
+	// The API base URL (defaults to https://api.opsgenie.com)
+	// e.g. https://api.eu.opsgenie.com for EU accounts
+	APIURL string `yaml:"api_url"`
+	// P1 to P5 (defaults to a priority based on the incident severity)
+	Priority   string
+	Responders []OpsgenieResponder
+	Tags       []string
+	// Close the alert when the incident is resolved
+	CloseOnResolve   bool `yaml:"close_on_resolve"`
+	HTTPClientConfig `yaml:",inline"`
+	RetryConfig      `yaml:",inline"`
+}
+
+// OpsgenieResponder is a team, user, escalation or schedule that an alert is
+// assigned to, identified by ID, Name or Username
+type OpsgenieResponder struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (c *OpsgenieConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.CloseOnResolve = true
+	c.MaxRetries = 3
+	type plain OpsgenieConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.APIKeyFile == "" {
+		return errors.New("opsgenie requires api_key_file")
+	}
+	return nil
+}
+
+// Opsgenie raises incidents as Opsgenie alerts, with the incident key as their alias
+type Opsgenie struct {
+	OpsgenieConfig
+	client lazyClient
+}
+
+func NewOpsgenie(config OpsgenieConfig) *Opsgenie {
+	return &Opsgenie{OpsgenieConfig: config}
+}
+
+type opsgenieAlert struct {
+	Message     string              `json:"message"`
+	Alias       string              `json:"alias"`
+	Description string              `json:"description,omitempty"`
+	Responders  []OpsgenieResponder `json:"responders,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Details     map[string]string   `json:"details,omitempty"`
+	Priority    string              `json:"priority,omitempty"`
+	Source      string              `json:"source"`
+}
+
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// opsgeniePriorities maps incident severities to alert priorities
+var opsgeniePriorities = map[string]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+func (o *Opsgenie) Trigger(ctx context.Context, incident Incident) error {
+	priority := o.Priority
+	if priority == "" {
+		priority = opsgeniePriorities[incident.Severity]
+	}
+	description := []string{incident.Summary}
+	for _, link := range incident.Links {
+		description = append(description, fmt.Sprintf("%s: %s", link.Text, link.Href))
+	}
+	return o.post(ctx, "/v2/alerts", opsgenieAlert{
+		// messages are limited to 130 characters
+		Message:     truncate(incident.Summary, 130),
+		Alias:       incident.Key,
+		Description: strings.Join(description, "\n"),
+		Responders:  o.Responders,
+		Tags:        o.Tags,
+		Details:     incident.Details,
+		Priority:    priority,
+		Source:      "alertdog",
+	})
+}
+
+func (o *Opsgenie) Resolve(ctx context.Context, incident Incident) error {
+	if !o.CloseOnResolve {
+		return nil
+	}
+	path := fmt.Sprintf("/v2/alerts/%s/close?identifierType=alias", url.PathEscape(incident.Key))
+	return o.post(ctx, path, opsgenieClose{Source: "alertdog", Note: "Resolved by alertdog"})
+}
+
+// Acknowledged reports if the alert for incident has been acknowledged or closed
+func (o *Opsgenie) Acknowledged(ctx context.Context, incident Incident) (bool, error) {
+	var alert struct {
+		Data struct {
+			Acknowledged bool   `json:"acknowledged"`
+			Status       string `json:"status"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/v2/alerts/%s?identifierType=alias", url.PathEscape(incident.Key))
+	if err := o.do(ctx, http.MethodGet, path, nil, http.StatusOK, &alert); err != nil {
+		return false, err
+	}
+	return alert.Data.Acknowledged || alert.Data.Status == "closed", nil
+}
+
+func (o *Opsgenie) post(ctx context.Context, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return o.do(ctx, http.MethodPost, path, data, http.StatusAccepted, nil)
+}
+
+// do makes a request to the API, decoding the response into result if it isn't nil
+func (o *Opsgenie) do(ctx context.Context, method, path string, body []byte, status int, result interface{}) error {
+	client, err := o.client.get(o.HTTPClientConfig)
+	if err != nil {
+		return err
+	}
+	key, err := ioutil.ReadFile(o.APIKeyFile)
+	if err != nil {
+		return err
+	}
+	apiURL := o.APIURL
+	if apiURL == "" {
+		apiURL = defaultOpsgenieAPIURL
+	}
+	return o.retry(ctx, func() error {
+		request, err := http.NewRequest(method, strings.TrimRight(apiURL, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "GenieKey "+strings.TrimSpace(string(key)))
+		response, err := client.Do(request)
+		if err != nil {
+			return retryableError{err: err}
+		}
+		defer response.Body.Close()
+		if response.StatusCode != status {
+			return statusError(response)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(response.Body).Decode(result)
+	})
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
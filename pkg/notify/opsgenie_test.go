@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestOpsgenie(t *testing.T) {
+	type request struct {
+		uri           string
+		authorization string
+		body          map[string]interface{}
+	}
+	requests := make(chan request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests <- request{uri: r.URL.RequestURI(), authorization: r.Header.Get("Authorization"), body: body}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"result": "Request will be processed", "requestId": "43a29c5c"}`))
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("eb243592-faa2-4ba2-a551q-1afdf565c889\n"), 0600))
+
+	opsgenie := NewOpsgenie(OpsgenieConfig{
+		APIKeyFile:     keyFile,
+		APIURL:         server.URL,
+		Responders:     []OpsgenieResponder{{Type: "team", Name: "sre"}},
+		Tags:           []string{"alertdog"},
+		CloseOnResolve: true,
+	})
+	incident := Incident{
+		Key:      "alertdog:alertmanager-push",
+		Summary:  "Alertdog cannot push alerts to alertmanager",
+		Severity: SeverityCritical,
+		Details:  map[string]string{"endpoints": "http://alertmanager:9093"},
+		Links:    []Link{{Text: "Runbook", Href: "https://example.org/runbook"}},
+	}
+
+	require.NoError(t, opsgenie.Trigger(context.Background(), incident))
+	trigger := <-requests
+	require.Equal(t, "/v2/alerts", trigger.uri)
+	require.Equal(t, "GenieKey eb243592-faa2-4ba2-a551q-1afdf565c889", trigger.authorization)
+	require.Equal(t, map[string]interface{}{
+		"message":     "Alertdog cannot push alerts to alertmanager",
+		"alias":       "alertdog:alertmanager-push",
+		"description": "Alertdog cannot push alerts to alertmanager\nRunbook: https://example.org/runbook",
+		"responders":  []interface{}{map[string]interface{}{"type": "team", "name": "sre"}},
+		"tags":        []interface{}{"alertdog"},
+		"details":     map[string]interface{}{"endpoints": "http://alertmanager:9093"},
+		"priority":    "P1",
+		"source":      "alertdog",
+	}, trigger.body)
+
+	require.NoError(t, opsgenie.Resolve(context.Background(), incident))
+	resolve := <-requests
+	require.Equal(t, "/v2/alerts/alertdog:alertmanager-push/close?identifierType=alias", resolve.uri)
+	require.Equal(t, "alertdog", resolve.body["source"])
+
+	// Alerts are left open if close_on_resolve is false
+	opsgenie.CloseOnResolve = false
+	require.NoError(t, opsgenie.Resolve(context.Background(), incident))
+	require.Empty(t, requests)
+}
+
+func TestOpsgeniePriority(t *testing.T) {
+	priorities := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert opsgenieAlert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		priorities <- alert.Priority
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("key"), 0600))
+
+	opsgenie := NewOpsgenie(OpsgenieConfig{APIKeyFile: keyFile, APIURL: server.URL})
+	require.NoError(t, opsgenie.Trigger(context.Background(), Incident{Key: "key", Severity: SeverityWarning}))
+	require.Equal(t, "P3", <-priorities)
+
+	opsgenie.Priority = "P2"
+	require.NoError(t, opsgenie.Trigger(context.Background(), Incident{Key: "key", Severity: SeverityWarning}))
+	require.Equal(t, "P2", <-priorities)
+}
+
+func TestOpsgenieConfig(t *testing.T) {
+	var config OpsgenieConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`api_key_file: /etc/alertdog/opsgenie`), &config))
+	require.True(t, config.CloseOnResolve)
+	require.Equal(t, 3, config.MaxRetries)
+
+	err := yaml.Unmarshal([]byte(`priority: P1`), &OpsgenieConfig{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "api_key_file")
+}
+
+func TestOpsgenieAcknowledged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		switch r.URL.RequestURI() {
+		case "/v2/alerts/acknowledged?identifierType=alias":
+			_, _ = w.Write([]byte(`{"data": {"acknowledged": true, "status": "open"}}`))
+		case "/v2/alerts/open?identifierType=alias":
+			_, _ = w.Write([]byte(`{"data": {"acknowledged": false, "status": "open"}}`))
+		case "/v2/alerts/closed?identifierType=alias":
+			_, _ = w.Write([]byte(`{"data": {"acknowledged": false, "status": "closed"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("key"), 0600))
+	opsgenie := NewOpsgenie(OpsgenieConfig{APIKeyFile: keyFile, APIURL: server.URL})
+
+	for key, expected := range map[string]bool{"acknowledged": true, "open": false, "closed": true} {
+		acknowledged, err := opsgenie.Acknowledged(context.Background(), Incident{Key: key})
+		require.NoError(t, err)
+		require.Equal(t, expected, acknowledged, key)
+	}
+	_, err := opsgenie.Acknowledged(context.Background(), Incident{Key: "missing"})
+	require.Error(t, err)
+}
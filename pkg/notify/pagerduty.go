@@ -0,0 +1,244 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+const defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyConfig configures how events are sent to PagerDuty
+type PagerDutyConfig struct {
+	// A PagerDuty Events API v2 routing key
+	RoutingKey string `yaml:"routing_key"`
+	// Routing keys used for particular types of incident, e.g. webhook-expiry
+	// (each defaults to RoutingKey)
+	RoutingKeys map[string]string `yaml:"routing_keys"`
+	// The events API endpoint (defaults to https://events.pagerduty.com/v2/enqueue)
+	// e.g. https://events.eu.pagerduty.com/v2/enqueue for the EU service region
+	URL              string
+	HTTPClientConfig `yaml:",inline"`
+	RetryConfig      `yaml:",inline"`
+
+	// text/template templates for the event payload, executed with TemplateData
+	// (each defaults to the incident's value)
+	Summary string
+	// Must render as critical, error, warning or info, otherwise the
+	// incident's severity is used
+	Severity      string
+	Component     string
+	Group         string
+	Class         string
+	CustomDetails map[string]string `yaml:"custom_details"`
+	// Links and images replace the incident's, an empty list removes them
+	Links  []Link
+	Images []Image
+}
+
+func (c *PagerDutyConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.MaxRetries = 3
+	type plain PagerDutyConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := ValidateRoutingKeys(c.RoutingKeys); err != nil {
+		return fmt.Errorf("routing_keys: %w", err)
+	}
+	if c.Severity != "" && !strings.Contains(c.Severity, "{{") && !validSeverity(c.Severity) {
+		return fmt.Errorf("severity: %q must be %q, %q, %q or %q", c.Severity, SeverityCritical, SeverityError, SeverityWarning, SeverityInfo)
+	}
+	_, err := c.payload(Incident{})
+	return err
+}
+
+// validSeverity reports if PagerDuty accepts severity
+func validSeverity(severity string) bool {
+	switch severity {
+	case SeverityCritical, SeverityError, SeverityWarning, SeverityInfo:
+		return true
+	}
+	return false
+}
+
+// PagerDuty raises incidents with the PagerDuty Events API v2
+type PagerDuty struct {
+	PagerDutyConfig
+	client lazyClient
+}
+
+func NewPagerDuty(config PagerDutyConfig) *PagerDuty {
+	return &PagerDuty{PagerDutyConfig: config}
+}
+
+// ValidateRoutingKeys checks that each of keys is for a type of incident
+func ValidateRoutingKeys(keys map[string]string) error {
+	for incidentType := range keys {
+		switch incidentType {
+		case TypeWebhookExpiry, TypeAlertmanagerPush:
+		default:
+			return fmt.Errorf("unknown incident type %q, must be %q or %q", incidentType, TypeWebhookExpiry, TypeAlertmanagerPush)
+		}
+	}
+	return nil
+}
+
+// routingKey returns the routing key that incident is sent to
+func (c PagerDutyConfig) routingKey(incident Incident) string {
+	if key, ok := c.RoutingKeys[incident.Type]; ok {
+		return key
+	}
+	return c.RoutingKey
+}
+
+func (p *PagerDuty) Trigger(ctx context.Context, incident Incident) error {
+	payload, err := p.payload(incident)
+	if err != nil {
+		return err
+	}
+	event := pagerduty.V2Event{
+		Action:     "trigger",
+		RoutingKey: p.routingKey(incident),
+		DedupKey:   incident.Key,
+		Payload:    &payload.V2Payload,
+	}
+	for _, link := range payload.links {
+		event.Links = append(event.Links, map[string]string{
+			"text": link.Text,
+			"href": link.Href,
+		})
+	}
+	for _, image := range payload.images {
+		i := map[string]string{"src": image.Src}
+		if image.Href != "" {
+			i["href"] = image.Href
+		}
+		if image.Alt != "" {
+			i["alt"] = image.Alt
+		}
+		event.Images = append(event.Images, i)
+	}
+	_, err = p.ManageEvent(ctx, event)
+	return err
+}
+
+type pagerDutyPayload struct {
+	pagerduty.V2Payload
+	links  []Link
+	images []Image
+}
+
+// payload executes the payload templates for incident
+func (c PagerDutyConfig) payload(incident Incident) (pagerDutyPayload, error) {
+	var err error
+	execute := func(name, text, fallback string) string {
+		if err != nil || text == "" {
+			return fallback
+		}
+		var result string
+		result, err = executeTemplate(name, text, incident, StatusFiring)
+		return result
+	}
+	payload := pagerDutyPayload{
+		V2Payload: pagerduty.V2Payload{
+			Summary:   execute("summary", c.Summary, incident.Summary),
+			Source:    incident.Key,
+			Severity:  execute("severity", c.Severity, incident.Severity),
+			Component: execute("component", c.Component, ""),
+			Group:     execute("group", c.Group, ""),
+			Class:     execute("class", c.Class, ""),
+		},
+		links:  incident.Links,
+		images: incident.Images,
+	}
+	if !validSeverity(payload.Severity) {
+		// PagerDuty rejects the event with any other severity
+		payload.Severity = incident.Severity
+	}
+	if len(c.CustomDetails) > 0 {
+		details := map[string]string{}
+		for key, text := range c.CustomDetails {
+			details[key] = execute("custom_details."+key, text, "")
+		}
+		payload.Details = details
+	} else if len(incident.Details) > 0 {
+		payload.Details = incident.Details
+	}
+	if c.Links != nil {
+		payload.links = nil
+		for _, link := range c.Links {
+			payload.links = append(payload.links, Link{
+				Text: execute("links.text", link.Text, ""),
+				Href: execute("links.href", link.Href, ""),
+			})
+		}
+	}
+	if c.Images != nil {
+		payload.images = nil
+		for _, image := range c.Images {
+			payload.images = append(payload.images, Image{
+				Src:  execute("images.src", image.Src, ""),
+				Href: execute("images.href", image.Href, ""),
+				Alt:  execute("images.alt", image.Alt, ""),
+			})
+		}
+	}
+	return payload, err
+}
+
+func (p *PagerDuty) Resolve(ctx context.Context, incident Incident) error {
+	_, err := p.ManageEvent(ctx, pagerduty.V2Event{
+		Action:     "resolve",
+		RoutingKey: p.routingKey(incident),
+		DedupKey:   incident.Key,
+	})
+	return err
+}
+
+// ManageEvent sends event to PagerDuty, retrying if it fails
+func (p *PagerDuty) ManageEvent(ctx context.Context, event pagerduty.V2Event) (*pagerduty.V2EventResponse, error) {
+	client, err := p.client.get(p.HTTPClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	var response *pagerduty.V2EventResponse
+	err = p.retry(ctx, func() (err error) {
+		response, err = p.send(ctx, client, body)
+		return err
+	})
+	return response, err
+}
+
+func (p *PagerDuty) send(ctx context.Context, client *http.Client, body []byte) (*pagerduty.V2EventResponse, error) {
+	url := p.URL
+	if url == "" {
+		url = defaultPagerDutyURL
+	}
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, retryableError{err: err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return nil, statusError(response)
+	}
+	var eventResponse pagerduty.V2EventResponse
+	if err := json.NewDecoder(response.Body).Decode(&eventResponse); err != nil {
+		return nil, err
+	}
+	return &eventResponse, nil
+}
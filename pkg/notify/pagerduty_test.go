@@ -0,0 +1,284 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+
+	"github.com/errm/alertdog/pkg/alertdogtest"
+	"github.com/errm/alertdog/pkg/notify"
+)
+
+func TestPagerDuty(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		requests   int
+		err        bool
+	}{
+		{
+			name:     "accepted",
+			requests: 1,
+		},
+		{
+			name:       "retries server errors",
+			statuses:   []int{http.StatusInternalServerError, http.StatusBadGateway},
+			maxRetries: 2,
+			requests:   3,
+		},
+		{
+			name:       "retries rate limits",
+			statuses:   []int{http.StatusTooManyRequests},
+			maxRetries: 2,
+			requests:   2,
+		},
+		{
+			name:       "gives up after max retries",
+			statuses:   []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			maxRetries: 1,
+			requests:   2,
+			err:        true,
+		},
+		{
+			name:       "doesn't retry invalid events",
+			statuses:   []int{http.StatusBadRequest},
+			maxRetries: 2,
+			requests:   1,
+			err:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := alertdogtest.NewPagerDuty()
+			defer fake.Close()
+			fake.FailNext(tc.statuses...)
+
+			config := fake.Config()
+			config.RetryConfig = notify.RetryConfig{
+				MaxRetries:   tc.maxRetries,
+				RetryBackoff: time.Millisecond,
+			}
+			response, err := notify.NewPagerDuty(config).ManageEvent(context.Background(), pagerduty.V2Event{Action: "trigger", DedupKey: "alertdog:webhook-expiry"})
+			if tc.err {
+				require.Error(t, err)
+				require.Empty(t, fake.Events())
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "alertdog:webhook-expiry", response.DedupKey)
+				require.Len(t, fake.Events(), 1)
+			}
+			require.Equal(t, tc.requests, fake.Requests())
+		})
+	}
+}
+
+func TestPagerDutyTimeout(t *testing.T) {
+	fake := alertdogtest.NewPagerDuty()
+	defer fake.Close()
+	fake.SetLatency(100 * time.Millisecond)
+
+	config := fake.Config()
+	config.Timeout = 10 * time.Millisecond
+	_, err := notify.NewPagerDuty(config).ManageEvent(context.Background(), pagerduty.V2Event{Action: "trigger"})
+	require.Error(t, err)
+}
+
+func TestPagerDutyProxy(t *testing.T) {
+	var proxied atomic.String
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(pagerduty.V2EventResponse{Status: "success"})
+	}))
+	defer proxy.Close()
+
+	client := notify.NewPagerDuty(notify.PagerDutyConfig{
+		URL:              "http://events.pagerduty.test/v2/enqueue",
+		HTTPClientConfig: notify.HTTPClientConfig{ProxyURL: proxy.URL},
+	})
+	_, err := client.ManageEvent(context.Background(), pagerduty.V2Event{Action: "trigger"})
+	require.NoError(t, err)
+	require.Equal(t, "http://events.pagerduty.test/v2/enqueue", proxied.Load())
+}
+
+func TestPagerDutyRetryAfter(t *testing.T) {
+	newFake := func(retryAfter string) *alertdogtest.PagerDuty {
+		fake := alertdogtest.NewPagerDuty()
+		t.Cleanup(fake.Close)
+		fake.SetRetryAfter(retryAfter)
+		fake.FailNext(http.StatusTooManyRequests)
+		return fake
+	}
+
+	t.Run("Retry-After is waited for", func(t *testing.T) {
+		fake := newFake("1")
+		config := fake.Config()
+		config.RetryConfig = notify.RetryConfig{MaxRetries: 4, RetryBackoff: 100 * time.Millisecond}
+		start := time.Now()
+		_, err := notify.NewPagerDuty(config).ManageEvent(context.Background(), pagerduty.V2Event{Action: "trigger"})
+		require.NoError(t, err)
+		require.Equal(t, 2, fake.Requests())
+		require.True(t, time.Since(start) >= time.Second)
+	})
+
+	t.Run("Retry-After is capped at the backoff of the last retry", func(t *testing.T) {
+		fake := newFake("3600")
+		config := fake.Config()
+		config.RetryConfig = notify.RetryConfig{MaxRetries: 2, RetryBackoff: 10 * time.Millisecond}
+		start := time.Now()
+		_, err := notify.NewPagerDuty(config).ManageEvent(context.Background(), pagerduty.V2Event{Action: "trigger"})
+		require.NoError(t, err)
+		require.Equal(t, 2, fake.Requests())
+		require.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("Waiting stops when the context is done", func(t *testing.T) {
+		fake := newFake("1")
+		config := fake.Config()
+		config.RetryConfig = notify.RetryConfig{MaxRetries: 3, RetryBackoff: time.Second}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := notify.NewPagerDuty(config).ManageEvent(ctx, pagerduty.V2Event{Action: "trigger"})
+		require.Error(t, err)
+		require.Equal(t, 1, fake.Requests())
+		require.True(t, time.Since(start) < time.Second)
+	})
+}
+
+func TestPagerDutyTrigger(t *testing.T) {
+	fake := alertdogtest.NewPagerDuty()
+	defer fake.Close()
+
+	config := fake.Config()
+	config.RoutingKey = "routing-key"
+	p := notify.NewPagerDuty(config)
+	incident := notify.Incident{
+		Key:      "alertdog:webhook-expiry",
+		Summary:  "Alertdog: didn't receive webhook from alert manager for over 5m0s",
+		Severity: notify.SeverityCritical,
+		Details:  map[string]string{"last_webhook": "2021-03-01T00:00:00Z"},
+		Links:    []notify.Link{{Text: "Runbook 📕", Href: "https://example.org/runbook"}},
+		Images:   []notify.Image{{Src: "https://example.org/dog.jpg"}},
+	}
+	require.NoError(t, p.Trigger(context.Background(), incident))
+	require.NoError(t, p.Resolve(context.Background(), incident))
+	require.Equal(t, []pagerduty.V2Event{
+		{
+			Action:     "trigger",
+			RoutingKey: "routing-key",
+			DedupKey:   "alertdog:webhook-expiry",
+			Payload: &pagerduty.V2Payload{
+				Summary:  "Alertdog: didn't receive webhook from alert manager for over 5m0s",
+				Source:   "alertdog:webhook-expiry",
+				Severity: "critical",
+				Details:  map[string]interface{}{"last_webhook": "2021-03-01T00:00:00Z"},
+			},
+			Links:  []interface{}{map[string]interface{}{"text": "Runbook 📕", "href": "https://example.org/runbook"}},
+			Images: []interface{}{map[string]interface{}{"src": "https://example.org/dog.jpg"}},
+		},
+		{
+			Action:     "resolve",
+			RoutingKey: "routing-key",
+			DedupKey:   "alertdog:webhook-expiry",
+		},
+	}, fake.Events())
+}
+
+func TestPagerDutyTemplates(t *testing.T) {
+	fake := alertdogtest.NewPagerDuty()
+	defer fake.Close()
+
+	var config notify.PagerDutyConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+summary: '{{ .Summary }} ({{ join .Context.FailingEndpoints ", " }})'
+severity: error
+component: alertmanager
+group: '{{ range .Context.AffectedTargets }}{{ .prometheus }} {{ end }}'
+class: '{{ .Key }}'
+custom_details:
+  last_webhook: '{{ .Context.LastWebhook.Format "15:04" }}'
+links:
+  - text: Dashboard
+    href: https://grafana.example.org/d/alertmanager
+images: []
+`), &config))
+	config.URL = fake.Config().URL
+
+	incident := notify.Incident{
+		Key:      "alertdog:alertmanager-push",
+		Summary:  "Alertdog cannot push alerts to alertmanager",
+		Severity: notify.SeverityCritical,
+		Links:    []notify.Link{{Text: "Runbook 📕", Href: "https://example.org/runbook"}},
+		Images:   []notify.Image{{Src: "https://example.org/dog.jpg"}},
+		Context: notify.Context{
+			FailingEndpoints: []string{"http://am1:9093", "http://am2:9093"},
+			LastWebhook:      time.Date(2021, time.March, 1, 12, 30, 0, 0, time.UTC),
+			AffectedTargets:  []map[string]string{{"prometheus": "prom1"}, {"prometheus": "prom2"}},
+		},
+	}
+	require.NoError(t, notify.NewPagerDuty(config).Trigger(context.Background(), incident))
+	require.Equal(t, []pagerduty.V2Event{{
+		Action:   "trigger",
+		DedupKey: "alertdog:alertmanager-push",
+		Payload: &pagerduty.V2Payload{
+			Summary:   "Alertdog cannot push alerts to alertmanager (http://am1:9093, http://am2:9093)",
+			Source:    "alertdog:alertmanager-push",
+			Severity:  "error",
+			Component: "alertmanager",
+			Group:     "prom1 prom2 ",
+			Class:     "alertdog:alertmanager-push",
+			Details:   map[string]interface{}{"last_webhook": "12:30"},
+		},
+		Links: []interface{}{map[string]interface{}{"text": "Dashboard", "href": "https://grafana.example.org/d/alertmanager"}},
+	}}, fake.Events())
+
+	require.Error(t, yaml.Unmarshal([]byte(`summary: '{{ .Summary'`), &notify.PagerDutyConfig{}))
+
+	// A severity PagerDuty would reject falls back to the incident's, or is
+	// an error when it isn't a template
+	require.Error(t, yaml.Unmarshal([]byte(`severity: high`), &notify.PagerDutyConfig{}))
+	config = fake.Config()
+	config.Severity = `{{ index .Details "level" }}`
+	for level, severity := range map[string]string{"warning": notify.SeverityWarning, "high": notify.SeverityCritical, "": notify.SeverityCritical} {
+		fake.Reset()
+		incident := notify.Incident{Key: "alertdog:webhook-expiry", Severity: notify.SeverityCritical, Details: map[string]string{"level": level}}
+		require.NoError(t, notify.NewPagerDuty(config).Trigger(context.Background(), incident))
+		require.Equal(t, severity, fake.Events()[0].Payload.Severity)
+	}
+}
+
+func TestPagerDutyRoutingKeys(t *testing.T) {
+	fake := alertdogtest.NewPagerDuty()
+	defer fake.Close()
+
+	var config notify.PagerDutyConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+routing_key: sre
+routing_keys:
+  alertmanager-push: alertmanager-owners
+`), &config))
+	config.URL = fake.Config().URL
+	p := notify.NewPagerDuty(config)
+
+	require.NoError(t, p.Trigger(context.Background(), notify.Incident{Key: "alertdog:prod:alertmanager-push", Type: notify.TypeAlertmanagerPush}))
+	require.NoError(t, p.Resolve(context.Background(), notify.Incident{Key: "alertdog:prod:webhook-expiry", Type: notify.TypeWebhookExpiry}))
+	events := fake.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, "alertmanager-owners", events[0].RoutingKey)
+	require.Equal(t, "alertdog:prod:alertmanager-push", events[0].DedupKey)
+	require.Equal(t, "sre", events[1].RoutingKey)
+	require.Equal(t, "alertdog:prod:webhook-expiry", events[1].DedupKey)
+
+	require.Error(t, yaml.Unmarshal([]byte(`routing_keys: {webhook_expiry: sre}`), &notify.PagerDutyConfig{}))
+}
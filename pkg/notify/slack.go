@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultSlackAPIURL = "https://slack.com/api"
+
+// SlackConfig configures how messages are posted to Slack.
+// Either WebhookURL, or TokenFile and Channel must be set.
+type SlackConfig struct {
+	// An incoming webhook URL, resolves are posted as new messages
+	WebhookURL string `yaml:"webhook_url"`
+	// A file containing a bot token, used to post with chat.postMessage, so
+	// resolves are posted in the thread of their trigger, which is updated
+	TokenFile string `yaml:"token_file"`
+	Channel   string
+	// The Web API base URL (defaults to https://slack.com/api)
+	APIURL           string `yaml:"api_url"`
+	HTTPClientConfig `yaml:",inline"`
+	RetryConfig      `yaml:",inline"`
+}
+
+func (c *SlackConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.MaxRetries = 3
+	type plain SlackConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.WebhookURL == "" && (c.TokenFile == "" || c.Channel == "") {
+		return errors.New("slack requires webhook_url, or token_file and channel")
+	}
+	return nil
+}
+
+// Slack posts incidents to a Slack channel
+type Slack struct {
+	SlackConfig
+	client lazyClient
+
+	mu sync.Mutex
+	// The messages posted for triggered incidents, by incident key, TS is
+	// only known when posting with the Web API
+	messages map[string]slackPosted
+}
+
+func NewSlack(config SlackConfig) *Slack {
+	return &Slack{SlackConfig: config, messages: map[string]slackPosted{}}
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+	ThreadTS    string            `json:"thread_ts,omitempty"`
+	TS          string            `json:"ts,omitempty"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color,omitempty"`
+	Title    string       `json:"title,omitempty"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields,omitempty"`
+	ImageURL string       `json:"image_url,omitempty"`
+	Footer   string       `json:"footer,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackPosted identifies a message posted with the Web API
+type slackPosted struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+type slackResponse struct {
+	slackPosted
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (s *Slack) Trigger(ctx context.Context, incident Incident) error {
+	message := s.message(incident, false)
+	posted, err := s.post(ctx, "chat.postMessage", message)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.messages[incident.Key] = posted
+	s.mu.Unlock()
+	return nil
+}
+
+// Resolve posts that incident is resolved. When the trigger was posted with
+// the Web API, the resolve is posted in its thread and the trigger is updated.
+// If the trigger was posted before alertdog restarted the resolve is posted
+// as a new message.
+func (s *Slack) Resolve(ctx context.Context, incident Incident) error {
+	s.mu.Lock()
+	trigger := s.messages[incident.Key]
+	s.mu.Unlock()
+	message := s.message(incident, true)
+	if trigger.TS != "" {
+		message.Channel = trigger.Channel
+		message.ThreadTS = trigger.TS
+	}
+	if _, err := s.post(ctx, "chat.postMessage", message); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.messages, incident.Key)
+	s.mu.Unlock()
+	if trigger.TS == "" {
+		return nil
+	}
+	update := s.message(incident, true)
+	update.Channel = trigger.Channel
+	update.TS = trigger.TS
+	_, err := s.post(ctx, "chat.update", update)
+	return err
+}
+
+func (s *Slack) message(incident Incident, resolved bool) slackMessage {
+	attachment := slackAttachment{
+		Color:  "danger",
+		Title:  incident.Summary,
+		Footer: incident.Key,
+	}
+	text := fmt.Sprintf(":rotating_light: *%s*: %s", strings.ToUpper(incident.Severity), incident.Summary)
+	if resolved {
+		attachment.Color = "good"
+		text = fmt.Sprintf(":white_check_mark: *RESOLVED*: %s", incident.Summary)
+	}
+	var links []string
+	for _, link := range incident.Links {
+		links = append(links, fmt.Sprintf("<%s|%s>", link.Href, link.Text))
+	}
+	attachment.Text = strings.Join(links, " ")
+	var keys []string
+	for key := range incident.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attachment.Fields = append(attachment.Fields, slackField{Title: key, Value: incident.Details[key]})
+	}
+	if len(incident.Images) > 0 && !resolved {
+		attachment.ImageURL = incident.Images[0].Src
+	}
+	return slackMessage{
+		Channel:     s.Channel,
+		Text:        text,
+		Attachments: []slackAttachment{attachment},
+	}
+}
+
+// post sends message to the incoming webhook, or the Web API method
+func (s *Slack) post(ctx context.Context, method string, message slackMessage) (slackPosted, error) {
+	client, err := s.client.get(s.HTTPClientConfig)
+	if err != nil {
+		return slackPosted{}, err
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return slackPosted{}, err
+	}
+	url, token := s.WebhookURL, ""
+	if s.TokenFile != "" {
+		url = s.APIURL
+		if url == "" {
+			url = defaultSlackAPIURL
+		}
+		url = strings.TrimRight(url, "/") + "/" + method
+		contents, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return slackPosted{}, err
+		}
+		token = strings.TrimSpace(string(contents))
+	}
+	var posted slackPosted
+	err = s.retry(ctx, func() (err error) {
+		posted, err = s.send(ctx, client, url, token, body)
+		return err
+	})
+	return posted, err
+}
+
+func (s *Slack) send(ctx context.Context, client *http.Client, url, token string, body []byte) (slackPosted, error) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return slackPosted{}, err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return slackPosted{}, retryableError{err: err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return slackPosted{}, statusError(response)
+	}
+	if token == "" {
+		// incoming webhooks respond with "ok"
+		return slackPosted{}, nil
+	}
+	var slackResponse slackResponse
+	if err := json.NewDecoder(response.Body).Decode(&slackResponse); err != nil {
+		return slackPosted{}, err
+	}
+	if !slackResponse.OK {
+		return slackPosted{}, fmt.Errorf("slack error: %s", slackResponse.Error)
+	}
+	return slackResponse.slackPosted, nil
+}
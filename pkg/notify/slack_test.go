@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slackServer is a stand in for the Slack incoming webhook and Web API
+type slackServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []slackRequest
+}
+
+type slackRequest struct {
+	path          string
+	authorization string
+	message       slackMessage
+}
+
+func newSlackServer(t *testing.T) *slackServer {
+	s := &slackServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message slackMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		s.mu.Lock()
+		s.requests = append(s.requests, slackRequest{
+			path:          r.URL.Path,
+			authorization: r.Header.Get("Authorization"),
+			message:       message,
+		})
+		s.mu.Unlock()
+		if r.URL.Path == "/webhook" {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer xoxb-token" {
+			_ = json.NewEncoder(w).Encode(slackResponse{Error: "invalid_auth"})
+			return
+		}
+		ts := message.TS
+		if ts == "" {
+			ts = "1614556800.000100"
+		}
+		_ = json.NewEncoder(w).Encode(slackResponse{OK: true, slackPosted: slackPosted{Channel: "C0123", TS: ts}})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *slackServer) Requests() []slackRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slackRequest(nil), s.requests...)
+}
+
+var slackIncident = Incident{
+	Key:      "alertdog:webhook-expiry",
+	Summary:  "Alertdog: didn't receive webhook from alert manager for over 5m0s",
+	Severity: SeverityCritical,
+	Links:    []Link{{Text: "Runbook 📕", Href: "https://example.org/runbook"}},
+	Images:   []Image{{Src: "https://example.org/dog.jpg"}},
+}
+
+func TestSlackWebhook(t *testing.T) {
+	server := newSlackServer(t)
+	slack := NewSlack(SlackConfig{WebhookURL: server.URL + "/webhook"})
+
+	require.NoError(t, slack.Trigger(context.Background(), slackIncident))
+	require.NoError(t, slack.Resolve(context.Background(), slackIncident))
+
+	requests := server.Requests()
+	require.Len(t, requests, 2)
+	trigger := requests[0].message
+	require.Equal(t, "", requests[0].authorization)
+	require.Contains(t, trigger.Text, "CRITICAL")
+	require.Equal(t, slackAttachment{
+		Color:    "danger",
+		Title:    "Alertdog: didn't receive webhook from alert manager for over 5m0s",
+		Text:     "<https://example.org/runbook|Runbook 📕>",
+		ImageURL: "https://example.org/dog.jpg",
+		Footer:   "alertdog:webhook-expiry",
+	}, trigger.Attachments[0])
+
+	resolve := requests[1].message
+	require.Contains(t, resolve.Text, "RESOLVED")
+	require.Equal(t, "good", resolve.Attachments[0].Color)
+	require.Equal(t, "alertdog:webhook-expiry", resolve.Attachments[0].Footer)
+}
+
+func TestSlackWebAPI(t *testing.T) {
+	server := newSlackServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("xoxb-token\n"), 0600))
+	slack := NewSlack(SlackConfig{APIURL: server.URL, TokenFile: tokenFile, Channel: "#sre"})
+
+	require.NoError(t, slack.Trigger(context.Background(), slackIncident))
+	require.NoError(t, slack.Resolve(context.Background(), slackIncident))
+
+	requests := server.Requests()
+	require.Len(t, requests, 3)
+	require.Equal(t, "/chat.postMessage", requests[0].path)
+	require.Equal(t, "Bearer xoxb-token", requests[0].authorization)
+	require.Equal(t, "#sre", requests[0].message.Channel)
+
+	// The resolve is posted in the thread of the trigger
+	require.Equal(t, "/chat.postMessage", requests[1].path)
+	require.Equal(t, "C0123", requests[1].message.Channel)
+	require.Equal(t, "1614556800.000100", requests[1].message.ThreadTS)
+	require.Contains(t, requests[1].message.Text, "RESOLVED")
+
+	// The trigger is updated to show it is resolved
+	require.Equal(t, "/chat.update", requests[2].path)
+	require.Equal(t, "1614556800.000100", requests[2].message.TS)
+	require.Equal(t, "good", requests[2].message.Attachments[0].Color)
+
+	// A trigger posted before a restart isn't known, so the resolve is a new message
+	restarted := NewSlack(SlackConfig{APIURL: server.URL, TokenFile: tokenFile, Channel: "#sre"})
+	require.NoError(t, restarted.Resolve(context.Background(), slackIncident))
+	requests = server.Requests()
+	require.Len(t, requests, 4)
+	require.Equal(t, "/chat.postMessage", requests[3].path)
+	require.Equal(t, "#sre", requests[3].message.Channel)
+	require.Equal(t, "", requests[3].message.ThreadTS)
+}
+
+func TestSlackWebAPIError(t *testing.T) {
+	server := newSlackServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("wrong"), 0600))
+	slack := NewSlack(SlackConfig{APIURL: server.URL, TokenFile: tokenFile, Channel: "#sre"})
+
+	require.EqualError(t, slack.Trigger(context.Background(), slackIncident), "slack error: invalid_auth")
+}
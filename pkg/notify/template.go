@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// Statuses of an incident, as they are shown in templates
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// TemplateData is what templates are executed with, e.g. {{ .Summary }}
+type TemplateData struct {
+	Incident
+	// firing or resolved
+	Status string
+}
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	// json quotes a value so it can be included in a JSON document
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// executeTemplate parses and executes text with the data for incident
+func executeTemplate(name, text string, incident Incident, status string) (string, error) {
+	t, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, TemplateData{Incident: incident, Status: status}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const defaultWebhookBody = `{"key": {{ .Key | json }}, "type": {{ .Type | json }}, "status": {{ .Status | json }}, "summary": {{ .Summary | json }}, "severity": {{ .Severity | json }}, "details": {{ .Details | json }}, "links": {{ .Links | json }}, "context": {{ .Context | json }}}`
+
+// WebhookConfig configures requests made to an arbitrary URL
+type WebhookConfig struct {
+	URL string
+	// The HTTP method (defaults to POST)
+	Method  string
+	Headers map[string]string
+	// A text/template template executed with TemplateData, (defaults to a
+	// JSON object with the incident, its status and context)
+	Body             string
+	HTTPClientConfig `yaml:",inline"`
+	RetryConfig      `yaml:",inline"`
+}
+
+func (c *WebhookConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.MaxRetries = 3
+	type plain WebhookConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.URL == "" {
+		return errors.New("webhook requires url")
+	}
+	_, err := parseTemplate("body", c.Body)
+	return err
+}
+
+// Webhook sends incidents to an HTTP endpoint, any 2xx response is a success
+type Webhook struct {
+	WebhookConfig
+	client lazyClient
+}
+
+func NewWebhook(config WebhookConfig) *Webhook {
+	return &Webhook{WebhookConfig: config}
+}
+
+func (w *Webhook) Trigger(ctx context.Context, incident Incident) error {
+	return w.send(ctx, incident, StatusFiring)
+}
+
+func (w *Webhook) Resolve(ctx context.Context, incident Incident) error {
+	return w.send(ctx, incident, StatusResolved)
+}
+
+func (w *Webhook) send(ctx context.Context, incident Incident, status string) error {
+	client, err := w.client.get(w.HTTPClientConfig)
+	if err != nil {
+		return err
+	}
+	bodyTemplate := w.Body
+	if bodyTemplate == "" {
+		bodyTemplate = defaultWebhookBody
+	}
+	body, err := executeTemplate("body", bodyTemplate, incident, status)
+	if err != nil {
+		return err
+	}
+	method := w.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	return w.retry(ctx, func() error {
+		request, err := http.NewRequest(method, w.URL, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		for name, value := range w.Headers {
+			request.Header.Set(name, value)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return retryableError{err: err}
+		}
+		defer response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return statusError(response)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+)
+
+type webhookRequest struct {
+	method string
+	header http.Header
+	body   string
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) (*httptest.Server, chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	var count atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		requests <- webhookRequest{method: r.Method, header: r.Header, body: string(body)}
+		if n := int(count.Inc()); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+var webhookIncident = Incident{
+	Key:      "alertdog:alertmanager-push",
+	Type:     TypeAlertmanagerPush,
+	Summary:  "Alertdog cannot push alerts to alertmanager",
+	Severity: SeverityCritical,
+	Links:    []Link{{Text: "Runbook", Href: "https://example.org/runbook"}},
+	Context: Context{
+		FailingEndpoints: []string{"http://alertmanager:9093"},
+		LastWebhook:      time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	server, requests := newWebhookServer(t)
+	webhook := NewWebhook(WebhookConfig{URL: server.URL})
+
+	require.NoError(t, webhook.Trigger(context.Background(), webhookIncident))
+	request := <-requests
+	require.Equal(t, http.MethodPost, request.method)
+	require.Equal(t, "application/json", request.header.Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(request.body), &body))
+	require.Equal(t, map[string]interface{}{
+		"key":      "alertdog:alertmanager-push",
+		"type":     "alertmanager-push",
+		"status":   "firing",
+		"summary":  "Alertdog cannot push alerts to alertmanager",
+		"severity": "critical",
+		"details":  nil,
+		"links":    []interface{}{map[string]interface{}{"text": "Runbook", "href": "https://example.org/runbook"}},
+		"context": map[string]interface{}{
+			"failing_endpoints": []interface{}{"http://alertmanager:9093"},
+			"last_webhook":      "2021-03-01T00:00:00Z",
+		},
+	}, body)
+
+	require.NoError(t, webhook.Resolve(context.Background(), webhookIncident))
+	require.Contains(t, (<-requests).body, `"status": "resolved"`)
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	server, requests := newWebhookServer(t)
+	webhook := NewWebhook(WebhookConfig{
+		URL:     server.URL,
+		Method:  http.MethodPut,
+		Headers: map[string]string{"Content-Type": "text/plain", "X-Api-Key": "secret"},
+		Body:    `{{ .Status | upper }} {{ .Key }}`,
+	})
+
+	require.NoError(t, webhook.Trigger(context.Background(), webhookIncident))
+	request := <-requests
+	require.Equal(t, http.MethodPut, request.method)
+	require.Equal(t, "text/plain", request.header.Get("Content-Type"))
+	require.Equal(t, "secret", request.header.Get("X-Api-Key"))
+	require.Equal(t, "FIRING alertdog:alertmanager-push", request.body)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	server, requests := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	webhook := NewWebhook(WebhookConfig{
+		URL:         server.URL,
+		RetryConfig: RetryConfig{MaxRetries: 1, RetryBackoff: time.Millisecond},
+	})
+	require.NoError(t, webhook.Trigger(context.Background(), webhookIncident))
+	require.Len(t, requests, 2)
+
+	server, _ = newWebhookServer(t, http.StatusNotFound)
+	webhook = NewWebhook(WebhookConfig{
+		URL:         server.URL,
+		RetryConfig: RetryConfig{MaxRetries: 1, RetryBackoff: time.Millisecond},
+	})
+	require.Error(t, webhook.Trigger(context.Background(), webhookIncident))
+
+	// Once ctx is done the request is still sent, but isn't retried
+	server, requests = newWebhookServer(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	webhook = NewWebhook(WebhookConfig{
+		URL:         server.URL,
+		RetryConfig: RetryConfig{MaxRetries: 1, RetryBackoff: time.Millisecond},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, webhook.Trigger(ctx, webhookIncident))
+	require.Len(t, requests, 1)
+}
+
+func TestWebhookConfig(t *testing.T) {
+	var config WebhookConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+url: https://incidents.example.org/api
+headers:
+  Authorization: Bearer token
+body: '{"title": {{ .Summary | json }}}'
+timeout: 5s
+`), &config))
+	require.Equal(t, 3, config.MaxRetries)
+	require.Equal(t, 5*time.Second, config.Timeout)
+	require.Equal(t, "Bearer token", config.Headers["Authorization"])
+
+	require.Error(t, yaml.Unmarshal([]byte(`method: PUT`), &WebhookConfig{}))
+	require.Error(t, yaml.Unmarshal([]byte(`{url: "https://example.org", body: "{{ .Key"}`), &WebhookConfig{}))
+}
@@ -0,0 +1,193 @@
+// Package web serves HTTP, optionally with TLS configured by a file in the
+// same format as the prometheus web configuration file
+// https://prometheus.io/docs/prometheus/latest/configuration/https/
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	TLSConfig *TLSConfig `yaml:"tls_server_config"`
+}
+
+type TLSConfig struct {
+	CertFile   string     `yaml:"cert_file"`
+	KeyFile    string     `yaml:"key_file"`
+	ClientAuth string     `yaml:"client_auth_type"`
+	ClientCAs  string     `yaml:"client_ca_file"`
+	MinVersion TLSVersion `yaml:"min_version"`
+}
+
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"TLS13": tls.VersionTLS13,
+	"TLS12": tls.VersionTLS12,
+	"TLS11": tls.VersionTLS11,
+	"TLS10": tls.VersionTLS10,
+}
+
+func (v *TLSVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	version, ok := tlsVersions[s]
+	if !ok {
+		return fmt.Errorf("unknown TLS version: %s", s)
+	}
+	*v = version
+	return nil
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// LoadConfig reads a web configuration file, keys that are not supported
+// are an error rather than being silently ignored
+func LoadConfig(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("invalid web config %s: %w", file, err)
+	}
+	return config, nil
+}
+
+// ServerConfig returns the tls.Config to serve with.
+// The certificate and key are reloaded when the files change, so they
+// can be rotated without restarting.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file must be set")
+	}
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type: %s", c.ClientAuth)
+	}
+	keyPair := &keyPair{certFile: c.CertFile, keyFile: c.KeyFile}
+	if _, err := keyPair.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     uint16(c.MinVersion),
+		ClientAuth:     clientAuth,
+		GetCertificate: keyPair.getCertificate,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if c.ClientCAs != "" {
+		caCerts, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAs)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("client_ca_file must be set to verify client certificates")
+	}
+	return config, nil
+}
+
+// keyPair loads a certificate, reloading it when the files are modified
+type keyPair struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (k *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	modified, err := lastModified(k.certFile, k.keyFile)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		if k.cert != nil {
+			// Keep serving the old certificate if the files are briefly missing while being replaced
+			return k.cert, nil
+		}
+		return nil, err
+	}
+	if k.cert != nil && !modified.After(k.modified) {
+		return k.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			// Keep serving the old certificate if the files are part way through being replaced
+			return k.cert, nil
+		}
+		return nil, err
+	}
+	k.cert = &cert
+	k.modified = modified
+	return k.cert, nil
+}
+
+func lastModified(files ...string) (time.Time, error) {
+	var modified time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+// Serve serves HTTP on listener, with TLS if configFile is set
+func Serve(server *http.Server, listener net.Listener, configFile string) error {
+	if configFile == "" {
+		return server.Serve(listener)
+	}
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if config.TLSConfig == nil {
+		return server.Serve(listener)
+	}
+	server.TLSConfig, err = config.TLSConfig.ServerConfig()
+	if err != nil {
+		return err
+	}
+	return server.ServeTLS(listener, "", "")
+}
+
+// ListenAndServe listens on server.Addr and serves HTTP, with TLS if
+// configFile is set
+func ListenAndServe(server *http.Server, configFile string) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(server, listener, configFile)
+}
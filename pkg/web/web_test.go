@@ -0,0 +1,157 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	configFile := filepath.Join(dir, "web.yml")
+
+	first := writeKeyPair(t, certFile, keyFile)
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(fmt.Sprintf(`
+tls_server_config:
+  cert_file: %s
+  key_file: %s
+  min_version: TLS12
+`, certFile, keyFile)), 0600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() {
+		_ = Serve(server, listener, configFile)
+	}()
+	defer server.Close()
+
+	get := func(trusted *x509.Certificate, maxVersion uint16) (*http.Response, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(trusted)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: maxVersion},
+		}}
+		response, err := client.Get("https://" + listener.Addr().String())
+		if err == nil {
+			response.Body.Close()
+		}
+		return response, err
+	}
+
+	response, err := get(first, 0)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	_, err = get(first, tls.VersionTLS11)
+	require.Error(t, err, "versions below min_version are refused")
+
+	// Rotate the certificate
+	second := writeKeyPair(t, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+
+	response, err = get(second, 0)
+	require.NoError(t, err)
+	require.Equal(t, second.SerialNumber, response.TLS.PeerCertificates[0].SerialNumber)
+
+	// The last certificate is served while the files are missing
+	require.NoError(t, os.Remove(keyFile))
+	response, err = get(second, 0)
+	require.NoError(t, err)
+	require.Equal(t, second.SerialNumber, response.TLS.PeerCertificates[0].SerialNumber)
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeKeyPair(t, certFile, keyFile)
+	writeKeyPair(t, caFile, filepath.Join(dir, "ca-key.pem"))
+
+	config := &TLSConfig{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ClientAuth: "RequireAndVerifyClientCert",
+		ClientCAs:  caFile,
+	}
+	tlsConfig, err := config.ServerConfig()
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.NotNil(t, tlsConfig.ClientCAs)
+
+	config.ClientCAs = ""
+	_, err = config.ServerConfig()
+	require.Error(t, err, "verifying client certificates needs a CA")
+
+	config.ClientAuth = "Sometimes"
+	_, err = config.ServerConfig()
+	require.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "web.yml")
+
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("tls_server_config:\n  min_version: TLS13\n"), 0600))
+	config, err := LoadConfig(configFile)
+	require.NoError(t, err)
+	require.Equal(t, TLSVersion(tls.VersionTLS13), config.TLSConfig.MinVersion)
+
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("tls_server_config:\n  min_version: SSL3\n"), 0600))
+	_, err = LoadConfig(configFile)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("basic_auth_users:\n  alice: hash\n"), 0600))
+	_, err = LoadConfig(configFile)
+	require.Error(t, err, "unsupported keys are an error")
+}
+
+// writeKeyPair writes a new self signed certificate for 127.0.0.1
+func writeKeyPair(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "alertdog"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}